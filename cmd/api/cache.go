@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/cache"
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/users"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newUserCache builds the user cache from CACHE_* settings, or returns nil
+// when caching is off. The returned function releases the backend.
+func newUserCache(logger *slog.Logger) (*cache.Cache[users.User], func()) {
+	ttl := config.Duration("CACHE_TTL", 5*time.Minute)
+	switch backend := config.String("CACHE_BACKEND", "memory"); backend {
+	case "off":
+		return nil, func() {}
+	case "memory":
+		return cache.New[users.User]("users", cache.NewLRU(config.Int("CACHE_SIZE", 10000)), ttl), func() {}
+	case "redis":
+		opts, err := redis.ParseURL(config.String("CACHE_REDIS_URL", "redis://localhost:6379/0"))
+		if err != nil {
+			logger.Error("invalid CACHE_REDIS_URL, user cache disabled", "error", err)
+			return nil, func() {}
+		}
+		client := redis.NewClient(opts)
+		store := cache.NewRedis(client, config.String("CACHE_REDIS_PREFIX", "craftfolio:"))
+		return cache.New[users.User]("users", store, ttl), func() { _ = client.Close() }
+	default:
+		logger.Warn("unknown cache backend, user cache disabled", "backend", backend)
+		return nil, func() {}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/jobs"
+	"gonesoft/go-dev-portfolio/internal/mail"
+	"gonesoft/go-dev-portfolio/internal/users"
+)
+
+// newJobWorker returns the background job worker with every handler registered.
+func newJobWorker(logger *slog.Logger, database *sql.DB) *jobs.Worker {
+	worker := jobs.NewWorker(database, config.Int("JOBS_CONCURRENCY", 4))
+	worker.Timeout = config.Duration("JOBS_TIMEOUT", 5*time.Minute)
+	worker.ShutdownTimeout = config.Duration("JOBS_SHUTDOWN_TIMEOUT", 20*time.Second)
+
+	jobs.Handle(worker, users.SendWelcomeEmail(newMailer(logger)))
+	jobs.Handle(worker, users.RunPurgeDeleted)
+	return worker
+}
+
+// newMailer sends through the SMTP relay in MAIL_SMTP_ADDR, or only logs the
+// messages when none is configured.
+func newMailer(logger *slog.Logger) mail.Mailer {
+	addr := config.String("MAIL_SMTP_ADDR", "")
+	if addr == "" {
+		return mail.LogMailer{Logger: logger}
+	}
+	return mail.SMTPMailer{
+		Addr:     addr,
+		From:     config.String("MAIL_FROM", "Craftfolio <no-reply@craftfolio.dev>"),
+		Username: config.String("MAIL_SMTP_USERNAME", ""),
+		Password: config.String("MAIL_SMTP_PASSWORD", ""),
+	}
+}
@@ -1,41 +1,270 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/changefeed"
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/events"
+	"gonesoft/go-dev-portfolio/internal/health"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/idempotency"
+	"gonesoft/go-dev-portfolio/internal/loadshed"
+	"gonesoft/go-dev-portfolio/internal/logging"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+	"gonesoft/go-dev-portfolio/internal/tracing"
 	"gonesoft/go-dev-portfolio/internal/users"
+	"gonesoft/go-dev-portfolio/internal/webhooks"
 )
 
 func main() {
+	logger := logging.Setup()
 
-	http.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			users.GetUsers(w, r)
-		case http.MethodPost:
-			users.CreateUser(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		logger.Error("set up tracing", "error", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	checker := newHealthChecker()
+	database, err := db.Connect()
+	if err != nil {
+		// keep serving; /readyz reports the outage and requests recover once it is back
+		logger.Warn("starting without the database", "error", err)
+	}
+	if database != nil {
+		if err := metrics.RegisterDB(database, "main"); err != nil {
+			logger.Error("register database metrics", "error", err)
+		}
+	}
+	for name, replica := range db.ReplicaPools() {
+		if err := metrics.RegisterDB(replica, name); err != nil {
+			logger.Error("register replica metrics", "replica", name, "error", err)
 		}
+	}
 
-	})
+	// background workers run until workerCtx is cancelled during shutdown
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	sched := newScheduler(logger)
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		sched.Run(workerCtx)
+	}()
+	if database != nil {
+		relay := events.NewRelay(database, config.Int("OUTBOX_BATCH_SIZE", 100), outboxSinks(logger, database)...)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			relay.Run(workerCtx, config.Duration("OUTBOX_POLL_INTERVAL", time.Second))
+		}()
 
-	http.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPut:
-			users.UpdateUser(w, r)
-		case http.MethodDelete:
-			users.DeleteUser(w, r)
-		case http.MethodGet:
-			users.GetUserByID(w, r)
-		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		worker := newJobWorker(logger, database)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker.Run(workerCtx, config.Duration("JOBS_POLL_INTERVAL", time.Second))
+		}()
+
+		dispatcher := webhooks.NewDispatcher(database,
+			&http.Client{Timeout: config.Duration("WEBHOOK_TIMEOUT", 10*time.Second)},
+			config.Int("WEBHOOK_MAX_ATTEMPTS", 8),
+			config.Int("WEBHOOK_BATCH_SIZE", 50))
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			dispatcher.Run(workerCtx, config.Duration("WEBHOOK_POLL_INTERVAL", 2*time.Second))
+		}()
+	}
+	hub := changefeed.NewHub()
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		hub.Run(workerCtx, 90*time.Second)
+	}()
+	userCache, closeCache := newUserCache(logger)
+	if userCache != nil {
+		users.UseCache(userCache)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			users.InvalidateOnChanges(workerCtx, hub)
+		}()
+	}
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		db.MonitorReplicas(workerCtx, config.Duration("DB_REPLICA_CHECK_INTERVAL", 5*time.Second))
+	}()
+
+	// middleware, innermost first
+	var handler http.Handler = httphelper.Routed(newRouter(checker, hub, sched))
+	handler = idempotency.Middleware(handler)
+	handler = db.RetryAfter(handler)
+	if limiter := newRateLimiter(logger, database); limiter != nil {
+		handler = limiter.Middleware(handler)
+	}
+	handler = auth.Middleware(handler)
+	handler = db.ReadYourWrites(config.Duration("DB_READ_YOUR_WRITES_WINDOW", 5*time.Second), handler)
+	handler = metrics.Middleware(handler)
+	if config.Bool("LOAD_SHED_ENABLED", true) {
+		shedder := loadshed.New(config.Int("LOAD_SHED_INITIAL_LIMIT", 100),
+			config.Int("LOAD_SHED_MIN_LIMIT", 10), config.Int("LOAD_SHED_MAX_LIMIT", 1000))
+		var exempt []string
+		for _, path := range strings.Split(config.String("LOAD_SHED_EXEMPT", "/healthz,/readyz,/metrics,/users/changes,/users/export"), ",") {
+			exempt = append(exempt, strings.TrimSpace(path))
 		}
-	})
+		handler = shedder.Middleware(handler, exempt...)
+	}
+	handler = tracing.Middleware(handler)
+	handler = httphelper.AccessLog(logger, handler)
+	handler = httphelper.RequestID(handler)
+	srv := &http.Server{
+		Addr:              config.String("HTTP_ADDR", ":8083"),
+		Handler:           handler,
+		ReadTimeout:       config.Duration("HTTP_READ_TIMEOUT", 15*time.Second),
+		ReadHeaderTimeout: config.Duration("HTTP_READ_HEADER_TIMEOUT", 5*time.Second),
+		WriteTimeout:      config.Duration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+		IdleTimeout:       config.Duration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		MaxHeaderBytes:    config.Int("HTTP_MAX_HEADER_BYTES", 1<<20),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+	// open change streams never finish on their own
+	srv.RegisterOnShutdown(hub.Close)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("server running", "addr", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		logger.Error("server stopped", "error", err)
+		exitCode = 1
+	case <-ctx.Done():
+		logger.Info("shutdown: signal received")
+	}
+	stop()
+
+	// 1. stop advertising readiness so the load balancer routes traffic away
+	checker.SetDraining()
+	if delay := config.Duration("SHUTDOWN_DRAIN_DELAY", 5*time.Second); exitCode == 0 && delay > 0 {
+		logger.Info("shutdown: draining", "delay", delay)
+		time.Sleep(delay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Duration("SHUTDOWN_TIMEOUT", 30*time.Second))
+	defer cancel()
+
+	// 2. stop accepting connections and wait for in-flight requests
+	logger.Info("shutdown: stopping http server")
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shutdown: in-flight requests did not finish, closing connections", "error", err)
+		_ = srv.Close()
+		exitCode = 1
+	}
 
-	fmt.Println("Server running on http://localhost:8083")
-	log.Fatal(http.ListenAndServe(":8083", nil))
+	// 3. stop background workers
+	logger.Info("shutdown: stopping background workers")
+	stopWorkers()
+	workers.Wait()
+	closeCache()
 
+	// 4. close the database pool
+	logger.Info("shutdown: closing database")
+	if err := db.Close(); err != nil {
+		logger.Error("shutdown: close database", "error", err)
+	}
+
+	// 5. flush pending spans
+	logger.Info("shutdown: flushing traces")
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("shutdown: flush traces", "error", err)
+	}
+
+	logger.Info("shutdown: complete")
+	os.Exit(exitCode)
+}
+
+// outboxSinks returns the sinks named in OUTBOX_SINKS; without it events are
+// logged and delivered to webhook subscriptions.
+func outboxSinks(logger *slog.Logger, database *sql.DB) []events.Sink {
+	var sinks []events.Sink
+	for _, name := range strings.Split(config.String("OUTBOX_SINKS", "log,webhooks"), ",") {
+		switch strings.TrimSpace(name) {
+		case "log":
+			sinks = append(sinks, events.LogSink{Logger: logger})
+		case "webhooks":
+			sinks = append(sinks, webhooks.NewSink(database))
+		case "":
+		default:
+			logger.Warn("unknown outbox sink", "sink", name)
+		}
+	}
+	return sinks
+}
+
+// newHealthChecker registers the readiness checks: the database and its schema
+// are critical, read replicas and the HTTP dependencies listed in HEALTH_HTTP_CHECKS
+// (name=url,name=url) are reported without failing readiness.
+func newHealthChecker() *health.Checker {
+	checker := health.NewChecker(config.Duration("HEALTH_CHECK_TIMEOUT", 2*time.Second))
+	checker.Register("database", true, func(ctx context.Context) error {
+		database, err := db.Connect()
+		if err != nil {
+			return err
+		}
+		if err := database.PingContext(ctx); err != nil {
+			db.MarkUnreachable(err)
+			return err
+		}
+		return nil
+	})
+	checker.Register("migrations", true, func(ctx context.Context) error {
+		database, err := db.Connect()
+		if err != nil {
+			return err
+		}
+		return db.CheckSchema(ctx, database)
+	})
+	if len(db.Replicas()) > 0 {
+		checker.Register("replicas", false, func(ctx context.Context) error {
+			var down []string
+			for _, rep := range db.Replicas() {
+				if !rep.Healthy {
+					down = append(down, rep.Name)
+				}
+			}
+			if len(down) > 0 {
+				return fmt.Errorf("unhealthy replicas: %s", strings.Join(down, ", "))
+			}
+			return nil
+		})
+	}
+	for _, dep := range strings.Split(config.String("HEALTH_HTTP_CHECKS", ""), ",") {
+		name, url, ok := strings.Cut(strings.TrimSpace(dep), "=")
+		if !ok {
+			continue
+		}
+		checker.Register(name, false, health.HTTPCheck(url))
+	}
+	return checker
 }
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/changefeed"
+	"gonesoft/go-dev-portfolio/internal/health"
+	"gonesoft/go-dev-portfolio/internal/openapi"
+	"gonesoft/go-dev-portfolio/internal/scheduler"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func loadSpec(t *testing.T) map[string]any {
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(openapi.Document, &doc))
+	return doc
+}
+
+// operations returns the operations of doc keyed by mux pattern, e.g. "GET /users/{id}".
+func operations(doc map[string]any) map[string]map[string]any {
+	ops := map[string]map[string]any{}
+	for path, item := range doc["paths"].(map[string]any) {
+		for method, op := range item.(map[string]any) {
+			if method != "parameters" {
+				ops[strings.ToUpper(method)+" "+path] = op.(map[string]any)
+			}
+		}
+	}
+	return ops
+}
+
+// resolve follows a local $ref.
+func resolve(doc map[string]any, node map[string]any) map[string]any {
+	for {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		var next any = doc
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			next = next.(map[string]any)[part]
+		}
+		node = next.(map[string]any)
+	}
+}
+
+// validate checks v against the subset of JSON Schema the document uses.
+func validate(doc map[string]any, schema map[string]any, v any, at string) error {
+	schema = resolve(doc, schema)
+	if alternatives, ok := schema["oneOf"].([]any); ok {
+		matched := 0
+		for _, alt := range alternatives {
+			if validate(doc, alt.(map[string]any), v, at) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fmt.Errorf("%s: matches %d of the oneOf schemas", at, matched)
+		}
+		return nil
+	}
+	if typ, ok := schema["type"]; ok {
+		types := []string{}
+		switch typ := typ.(type) {
+		case string:
+			types = append(types, typ)
+		case []any:
+			for _, t := range typ {
+				types = append(types, t.(string))
+			}
+		}
+		if !slices.Contains(types, jsonType(v)) && !(jsonType(v) == "integer" && slices.Contains(types, "number")) {
+			return fmt.Errorf("%s: %s is not %v", at, jsonType(v), types)
+		}
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, v) {
+		return fmt.Errorf("%s: %v is not one of %v", at, v, enum)
+	}
+	switch v := v.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing required %s", at, name)
+			}
+		}
+		for name, value := range v {
+			prop, ok := props[name].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: undocumented property %s", at, name)
+				}
+				continue
+			}
+			if err := validate(doc, prop, value, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validate(doc, items, item, at+"["+strconv.Itoa(i)+"]"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+func TestOpenAPICoversUserRoutes(t *testing.T) {
+	doc := loadSpec(t)
+	documented := map[string]bool{}
+	for pattern := range operations(doc) {
+		documented[pattern] = true
+	}
+
+	registered := map[string]bool{}
+	for _, r := range userRoutes(changefeed.NewHub()) {
+		registered[r.pattern] = true
+		assert.True(t, documented[r.pattern], "route %s is not in openapi.json", r.pattern)
+	}
+	for pattern := range documented {
+		assert.True(t, registered[pattern], "openapi.json documents %s, which is not routed", pattern)
+	}
+}
+
+// TestOpenAPIResponses runs requests that the handlers answer without the
+// database and checks that status and body are the documented ones.
+func TestOpenAPIResponses(t *testing.T) {
+	t.Setenv("HTTP_MAX_BODY_BYTES", "256")
+	t.Setenv("USERS_IMPORT_MAX_BYTES", "256")
+	t.Setenv("AUTH_TRUST_HEADERS", "true")
+	t.Setenv("AUTH_GATEWAY_SECRET", "gateway")
+	doc := loadSpec(t)
+	ops := operations(doc)
+	mux := newRouter(health.NewChecker(time.Second), changefeed.NewHub(), scheduler.New(time.UTC))
+	handler := auth.Middleware(mux)
+
+	tests := []struct {
+		method, target, body string
+		headers              map[string]string
+		status               int
+	}{
+		{"GET", "/users?sort=password", "", nil, http.StatusBadRequest},
+		{"POST", "/users", `{}`, nil, http.StatusBadRequest},
+		{"POST", "/users", `not json`, nil, http.StatusBadRequest},
+		{"POST", "/users", `{"name":"<b>Ada</b>","email":"Ada <ada@example.com>"}`, nil, http.StatusBadRequest},
+		{"POST", "/users", `{"name":"Ada","email":"ada@example.com","admin":true}`, nil, http.StatusBadRequest},
+		{"POST", "/users", `{"name":"` + strings.Repeat("a", 300) + `"}`, nil, http.StatusRequestEntityTooLarge},
+		{"POST", "/users:batch", `{"operations":[]}`, nil, http.StatusBadRequest},
+		{"POST", "/users:batch", `{"operations":[{"op":"rename"}]}`, nil, http.StatusBadRequest},
+		{"POST", "/users:batch", `{"operations":[{"op":"update","id":-1,"name":"Ada","email":"ada"}]}`, nil, http.StatusBadRequest},
+		{"GET", "/users/export?format=xml", "", nil, http.StatusBadRequest},
+		{"GET", "/users/export?sort=password", "", nil, http.StatusBadRequest},
+		{"POST", "/users/import?format=xml", "", nil, http.StatusBadRequest},
+		{"POST", "/users/import", "id\n1\n", map[string]string{"Content-Type": "text/csv"}, http.StatusBadRequest},
+		{"POST", "/users/import?dry_run=true", "name,email\n,ada@example.com\n", map[string]string{"Content-Type": "text/csv"}, http.StatusOK},
+		{"POST", "/users/import", "name,email\n" + strings.Repeat("Ada,ada@example.com\n", 20), map[string]string{"Content-Type": "text/csv"}, http.StatusRequestEntityTooLarge},
+		{"GET", "/users/changes", "", nil, http.StatusUnauthorized},
+		{"GET", "/users/changes", "", map[string]string{auth.SecretHeader: "gateway", auth.SubjectHeader: "7", "Last-Event-ID": "x"}, http.StatusBadRequest},
+		{"GET", "/users/0", "", nil, http.StatusBadRequest},
+		{"PUT", "/users/1", `{"name":"Ada","email":"ada@example.com"}`, nil, http.StatusPreconditionRequired},
+		{"PUT", "/users/1", `{}`, map[string]string{"If-Match": `"1"`}, http.StatusBadRequest},
+		{"PATCH", "/users/1", `{}`, map[string]string{"If-Match": `W/"1"`}, http.StatusPreconditionFailed},
+		{"PATCH", "/users/1", `{}`, map[string]string{"If-Match": "*", "Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"DELETE", "/users/abc", "", nil, http.StatusBadRequest},
+		{"DELETE", "/users/1", "", nil, http.StatusPreconditionRequired},
+		{"POST", "/users/0/restore", "", nil, http.StatusBadRequest},
+		{"POST", "/users/1/restore", "", map[string]string{"If-Match": "nope"}, http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		name := tt.method + " " + tt.target
+		req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, tt.status, rec.Code, name+": "+rec.Body.String())
+
+		_, pattern := mux.Handler(req)
+		op, ok := ops[pattern]
+		require.True(t, ok, "%s: %s is not documented", name, pattern)
+		response, ok := op["responses"].(map[string]any)[strconv.Itoa(rec.Code)].(map[string]any)
+		require.True(t, ok, "%s: status %d is not documented", name, rec.Code)
+		response = resolve(doc, response)
+
+		content, _ := response["content"].(map[string]any)
+		media, ok := content["application/json"].(map[string]any)
+		require.True(t, ok, "%s: no application/json response documented for %d", name, rec.Code)
+		assert.Contains(t, rec.Header().Get("Content-Type"), "application/json", name)
+		var body any
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body), name)
+		assert.NoError(t, validate(doc, media["schema"].(map[string]any), body, "body"), name)
+	}
+}
+
+func TestOpenAPIServed(t *testing.T) {
+	mux := newRouter(health.NewChecker(time.Second), changefeed.NewHub(), scheduler.New(time.UTC))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(openapi.Document), rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/docs", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "/openapi.json")
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"strings"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/ratelimit"
+)
+
+// defaultRateLimitRoutes are stricter on writes and bulk operations and leave
+// probes and metrics alone.
+const defaultRateLimitRoutes = "POST /users=30/m,POST /users:batch=10/m,POST /users/import=5/m," +
+	"GET /users/export=10/m,GET /healthz=off,GET /readyz=off,GET /metrics=off"
+
+// newRateLimiter builds the limiter from RATE_LIMIT_* settings, or returns nil
+// when rate limiting is disabled. RATE_LIMIT_ROUTES lists pattern=policy
+// pairs separated by commas, e.g. "POST /login=5/m,GET /healthz=off".
+func newRateLimiter(logger *slog.Logger, database *sql.DB) *ratelimit.Limiter {
+	if !config.Bool("RATE_LIMIT_ENABLED", true) {
+		return nil
+	}
+
+	var store ratelimit.Store = ratelimit.NewMemoryStore()
+	switch backend := config.String("RATE_LIMIT_BACKEND", "memory"); backend {
+	case "memory":
+	case "postgres":
+		if database == nil {
+			logger.Warn("rate limit backend postgres needs the database, limiting in memory")
+			break
+		}
+		store = ratelimit.NewPostgresStore(database)
+	default:
+		logger.Warn("unknown rate limit backend, limiting in memory", "backend", backend)
+	}
+
+	fallback, err := ratelimit.ParsePolicy("default", config.String("RATE_LIMIT_DEFAULT", "300/m"))
+	if err != nil {
+		logger.Error("invalid RATE_LIMIT_DEFAULT, using 300/m", "error", err)
+		fallback, _ = ratelimit.ParsePolicy("default", "300/m")
+	}
+	limiter := ratelimit.NewLimiter(store, fallback)
+
+	for _, entry := range strings.Split(config.String("RATE_LIMIT_ROUTES", defaultRateLimitRoutes), ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		i := strings.LastIndex(entry, "=")
+		if i < 0 {
+			logger.Error("invalid RATE_LIMIT_ROUTES entry", "entry", entry)
+			continue
+		}
+		pattern := strings.TrimSpace(entry[:i])
+		policy, err := ratelimit.ParsePolicy(pattern, entry[i+1:])
+		if err == nil {
+			err = limiter.Route(pattern, policy)
+		}
+		if err != nil {
+			logger.Error("invalid RATE_LIMIT_ROUTES entry", "entry", entry, "error", err)
+		}
+	}
+	return limiter
+}
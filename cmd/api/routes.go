@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+
+	"gonesoft/go-dev-portfolio/internal/audit"
+	"gonesoft/go-dev-portfolio/internal/changefeed"
+	"gonesoft/go-dev-portfolio/internal/health"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+	"gonesoft/go-dev-portfolio/internal/openapi"
+	"gonesoft/go-dev-portfolio/internal/scheduler"
+	"gonesoft/go-dev-portfolio/internal/users"
+	"gonesoft/go-dev-portfolio/internal/webhooks"
+)
+
+// newRouter registers every API route on a fresh mux.
+func newRouter(checker *health.Checker, hub *changefeed.Hub, sched *scheduler.Scheduler) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	for _, route := range userRoutes(hub) {
+		mux.HandleFunc(route.pattern, route.handler)
+	}
+
+	mux.HandleFunc("GET /audit", audit.ListAuditEvents)
+
+	mux.HandleFunc("GET /scheduler/tasks", sched.ListTasks)
+
+	mux.HandleFunc("GET /webhooks", webhooks.ListSubscriptions)
+	mux.HandleFunc("POST /webhooks", webhooks.CreateSubscription)
+	mux.HandleFunc("GET /webhooks/{id}", webhooks.GetSubscription)
+	mux.HandleFunc("PUT /webhooks/{id}", webhooks.UpdateSubscription)
+	mux.HandleFunc("DELETE /webhooks/{id}", webhooks.DeleteSubscription)
+	mux.HandleFunc("GET /webhooks/{id}/deliveries", webhooks.ListDeliveries)
+	mux.HandleFunc("GET /webhooks/{id}/deliveries/{delivery}", webhooks.GetDelivery)
+	mux.HandleFunc("POST /webhooks/{id}/deliveries/{delivery}/redeliver", webhooks.Redeliver)
+
+	mux.HandleFunc("GET /healthz", checker.Live)
+	mux.HandleFunc("GET /readyz", checker.Ready)
+
+	mux.Handle("/metrics", metrics.Handler())
+
+	mux.HandleFunc("GET /openapi.json", openapi.ServeDocument)
+	mux.HandleFunc("GET /docs", openapi.ServeDocs)
+
+	return mux
+}
+
+type route struct {
+	pattern string
+	handler http.HandlerFunc
+}
+
+// userRoutes are the /users endpoints. internal/openapi/openapi.json
+// describes each of them; the contract tests fail if the two drift apart.
+func userRoutes(hub *changefeed.Hub) []route {
+	return []route{
+		{"GET /users", users.GetUsers},
+		{"POST /users", users.CreateUser},
+		{"POST /users:batch", users.BatchUsers},
+		{"GET /users/export", users.ExportUsers},
+		{"POST /users/import", users.ImportUsers},
+		{"GET /users/changes", hub.Stream},
+		{"GET /users/{id}", users.GetUserByID},
+		{"PUT /users/{id}", users.UpdateUser},
+		{"PATCH /users/{id}", users.PatchUser},
+		{"DELETE /users/{id}", users.DeleteUser},
+		{"POST /users/{id}/restore", users.RestoreUser},
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/changefeed"
+	"gonesoft/go-dev-portfolio/internal/health"
+	"gonesoft/go-dev-portfolio/internal/scheduler"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouterPatterns(t *testing.T) {
+	// registering conflicting patterns panics
+	mux := newRouter(health.NewChecker(time.Second), changefeed.NewHub(), scheduler.New(time.UTC))
+
+	tests := []struct{ method, path, pattern string }{
+		{"GET", "/users/7", "GET /users/{id}"},
+		{"GET", "/users/export", "GET /users/export"},
+		{"GET", "/users/changes", "GET /users/changes"},
+		{"POST", "/users/7/restore", "POST /users/{id}/restore"},
+		{"GET", "/scheduler/tasks", "GET /scheduler/tasks"},
+		{"GET", "/webhooks/3/deliveries", "GET /webhooks/{id}/deliveries"},
+		{"GET", "/webhooks/3/deliveries/9", "GET /webhooks/{id}/deliveries/{delivery}"},
+		{"POST", "/webhooks/3/deliveries/9/redeliver", "POST /webhooks/{id}/deliveries/{delivery}/redeliver"},
+	}
+	for _, tt := range tests {
+		_, pattern := mux.Handler(httptest.NewRequest(tt.method, tt.path, nil))
+		assert.Equal(t, tt.pattern, pattern, tt.method+" "+tt.path)
+	}
+}
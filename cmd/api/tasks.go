@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strings"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/changefeed"
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/events"
+	"gonesoft/go-dev-portfolio/internal/idempotency"
+	"gonesoft/go-dev-portfolio/internal/jobs"
+	"gonesoft/go-dev-portfolio/internal/ratelimit"
+	"gonesoft/go-dev-portfolio/internal/scheduler"
+	"gonesoft/go-dev-portfolio/internal/users"
+)
+
+// newScheduler registers the maintenance tasks. Each schedule can be
+// overridden with SCHEDULE_<TASK>, e.g. SCHEDULE_PURGE_DELETED_USERS.
+func newScheduler(logger *slog.Logger) *scheduler.Scheduler {
+	loc, err := time.LoadLocation(config.String("SCHEDULER_TIMEZONE", "UTC"))
+	if err != nil {
+		logger.Error("invalid SCHEDULER_TIMEZONE, using UTC", "error", err)
+		loc = time.UTC
+	}
+	s := scheduler.New(loc)
+
+	tasks := []scheduler.Task{
+		jobTask("purge-deleted-users", "30 3 * * *", scheduler.RunOnce, users.PurgeDeleted{
+			OlderThan: config.Duration("USERS_PURGE_AFTER", 30*24*time.Hour),
+		}),
+		maintenanceTask("purge-idempotency-keys", "*/15 * * * *", scheduler.Skip, idempotency.PurgeExpired),
+		maintenanceTask("purge-outbox", "@hourly", scheduler.Skip, func(ctx context.Context, q *sql.DB) (int64, error) {
+			return events.PurgePublished(ctx, q, config.Duration("OUTBOX_RETENTION", 7*24*time.Hour))
+		}),
+		maintenanceTask("purge-user-changes", "@hourly", scheduler.Skip, func(ctx context.Context, q *sql.DB) (int64, error) {
+			return changefeed.PurgeChanges(ctx, q, config.Duration("USER_CHANGES_RETENTION", 24*time.Hour))
+		}),
+		maintenanceTask("purge-rate-limits", "@hourly", scheduler.Skip, func(ctx context.Context, q *sql.DB) (int64, error) {
+			return ratelimit.PurgeIdle(ctx, q, 24*time.Hour)
+		}),
+		maintenanceTask("purge-jobs", "15 4 * * *", scheduler.RunOnce, func(ctx context.Context, q *sql.DB) (int64, error) {
+			return jobs.PurgeFinished(ctx, q, config.Duration("JOBS_RETENTION", 7*24*time.Hour))
+		}),
+		maintenanceTask("canonicalize-emails", "45 4 * * *", scheduler.RunOnce, func(ctx context.Context, q *sql.DB) (int64, error) {
+			return users.RecanonicalizeEmails(ctx, q)
+		}),
+	}
+	for _, t := range tasks {
+		if err := s.Add(t); err != nil {
+			logger.Error("scheduled task disabled", "task", t.Name, "error", err)
+		}
+	}
+	return s
+}
+
+// maintenanceTask wraps a function that purges or fixes up rows as a task
+// scheduled by SCHEDULE_<NAME>, falling back to schedule.
+func maintenanceTask(name, schedule string, missed scheduler.MissedRunPolicy, run func(ctx context.Context, q *sql.DB) (int64, error)) scheduler.Task {
+	key := "SCHEDULE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	return scheduler.Task{
+		Name:     name,
+		Schedule: config.String(key, schedule),
+		Missed:   missed,
+		Run: func(ctx context.Context) error {
+			database, err := db.Connect()
+			if err != nil {
+				return err
+			}
+			n, err := run(ctx, database)
+			if err != nil {
+				return err
+			}
+			slog.Debug("maintenance task done", "task", name, "rows", n)
+			return nil
+		},
+	}
+}
+
+// jobTask enqueues job on schedule so that it runs on the job worker, with
+// its retries, instead of inside the scheduler. A run is skipped while the
+// previous job is still queued.
+func jobTask(name, schedule string, missed scheduler.MissedRunPolicy, job jobs.Job) scheduler.Task {
+	key := "SCHEDULE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	return scheduler.Task{
+		Name:     name,
+		Schedule: config.String(key, schedule),
+		Missed:   missed,
+		Run: func(ctx context.Context) error {
+			database, err := db.Connect()
+			if err != nil {
+				return err
+			}
+			_, err = jobs.Enqueue(ctx, database, job, jobs.Unique(job.Kind()))
+			return err
+		},
+	}
+}
@@ -8,13 +8,292 @@ CREATE TABLE IF NOT EXISTS users (
 -- Avoid duplicate entries in the email column
 ALTER TABLE users
     ADD CONSTRAINT users_email_unique UNIQUE (email);
+
+ALTER TABLE users
     ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
 
+-- row version used for optimistic concurrency (ETag / If-Match)
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
 -- unique index to ensure case-insensitive uniqueness
 CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_unique
 ON users (LOWER(TRIM(email)))
 WHERE deleted_at IS NULL;
 
+-- canonical email (see internal/emailaddr) used for uniqueness, lookups and
+-- search; email keeps the address as the user typed it. The API always sets
+-- it. Rows inserted without it get LOWER(TRIM(email)) until the
+-- canonicalize-emails task rewrites them.
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email_canonical TEXT;
+
+CREATE OR REPLACE FUNCTION users_default_email_canonical() RETURNS trigger AS $$
+BEGIN
+    IF NEW.email_canonical IS NULL THEN
+        NEW.email_canonical := LOWER(TRIM(NEW.email));
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_default_email_canonical ON users;
+CREATE TRIGGER users_default_email_canonical
+BEFORE INSERT ON users
+FOR EACH ROW EXECUTE FUNCTION users_default_email_canonical();
+
+UPDATE users SET email_canonical = LOWER(TRIM(email)) WHERE email_canonical IS NULL;
+
+ALTER TABLE users
+    ALTER COLUMN email_canonical SET NOT NULL;
+
+-- uniqueness among live users now follows the canonical form alone
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_unique;
+DROP INDEX IF EXISTS users_email_lower_unique;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_canonical_unique
+ON users (email_canonical)
+WHERE deleted_at IS NULL;
+
+-- stored responses for Idempotency-Key replays
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+    status_code INTEGER NULL,
+    response_headers JSONB NULL,
+    response_body BYTEA NULL,
+    response_hash TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at
+ON idempotency_keys (expires_at);
+
+-- keys are scoped to the authenticated principal so clients cannot collide
+ALTER TABLE idempotency_keys
+    ADD COLUMN IF NOT EXISTS principal TEXT NOT NULL DEFAULT 'anonymous';
+
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idempotency_keys_principal_key
+ON idempotency_keys (principal, key);
+
+-- append-only record of every user mutation
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete', 'restore')),
+    user_id INTEGER NOT NULL,
+    changes JSONB NOT NULL DEFAULT '{}',
+    request_id TEXT NULL,
+    ip TEXT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_user_id
+ON audit_events (user_id, occurred_at);
+
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at
+ON audit_events (occurred_at);
+
+CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events;
+CREATE TRIGGER audit_events_append_only
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+-- domain events waiting to be published by the outbox relay
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    aggregate_id INTEGER NOT NULL,
+    payload JSONB NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_error TEXT NULL,
+    published_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending
+ON outbox (aggregate_id, id)
+WHERE published_at IS NULL;
+
+-- partner endpoints that receive user events
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id SERIAL PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- one event sent to one subscription; dead once the attempts are used up
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_status_code INTEGER NULL,
+    last_error TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMPTZ NULL,
+    UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due
+ON webhook_deliveries (next_attempt_at)
+WHERE status = 'pending';
+
+-- every HTTP request made for a delivery
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    status_code INTEGER NULL,
+    error TEXT NULL,
+    duration_ms DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_delivery_attempts_delivery
+ON webhook_delivery_attempts (delivery_id);
+
+-- feed of user changes behind GET /users/changes; each row is announced with
+-- NOTIFY user_changes carrying its id
+CREATE TABLE IF NOT EXISTS user_changes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    op TEXT NOT NULL CHECK (op IN ('create', 'update', 'delete', 'restore')),
+    data JSONB NOT NULL,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_changes_changed_at
+ON user_changes (changed_at);
+
+CREATE OR REPLACE FUNCTION record_user_change() RETURNS trigger AS $$
+DECLARE
+    change_op TEXT;
+    change_id BIGINT;
+    u users;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        change_op := 'create';
+        u := NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        -- purging a soft-deleted user is not news to the feed
+        IF OLD.deleted_at IS NOT NULL THEN
+            RETURN NULL;
+        END IF;
+        change_op := 'delete';
+        u := OLD;
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        change_op := 'delete';
+        u := NEW;
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        change_op := 'restore';
+        u := NEW;
+    ELSIF (OLD.name, OLD.email, OLD.version) IS NOT DISTINCT FROM (NEW.name, NEW.email, NEW.version) THEN
+        -- e.g. a recomputed email_canonical: nothing a client sees has changed
+        RETURN NULL;
+    ELSE
+        change_op := 'update';
+        u := NEW;
+    END IF;
+
+    INSERT INTO user_changes (user_id, op, data)
+    VALUES (u.id, change_op, jsonb_build_object(
+        'id', u.id, 'name', u.name, 'email', u.email, 'version', u.version))
+    RETURNING id INTO change_id;
+    -- only the id: NOTIFY payloads are limited to 8000 bytes
+    PERFORM pg_notify('user_changes', change_id::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_record_change ON users;
+CREATE TRIGGER users_record_change
+AFTER INSERT OR UPDATE OR DELETE ON users
+FOR EACH ROW EXECUTE FUNCTION record_user_change();
+
+-- background job queue; unique_key blocks duplicates while a job is pending or running
+CREATE TABLE IF NOT EXISTS jobs (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'succeeded', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 5,
+    unique_key TEXT NULL,
+    run_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_at TIMESTAMPTZ NULL,
+    last_error TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS jobs_due
+ON jobs (run_at, id)
+WHERE status IN ('pending', 'running');
+
+CREATE UNIQUE INDEX IF NOT EXISTS jobs_unique_key
+ON jobs (unique_key)
+WHERE status IN ('pending', 'running');
+
+-- shared state of the scheduler's recurring tasks
+CREATE TABLE IF NOT EXISTS scheduled_tasks (
+    name TEXT PRIMARY KEY,
+    schedule TEXT NOT NULL,
+    next_run_at TIMESTAMPTZ NOT NULL,
+    last_run_at TIMESTAMPTZ NULL,
+    last_status TEXT NULL CHECK (last_status IN ('succeeded', 'failed', 'skipped')),
+    last_error TEXT NULL,
+    last_duration_ms DOUBLE PRECISION NULL
+);
+
+-- token buckets of the shared rate limiter; losing them on a crash only resets the limits
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+-- applied schema changes; db.SchemaVersion must match the highest version
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    description TEXT NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO schema_migrations (version, description) VALUES
+(1, 'users'),
+(2, 'users.created_at and users.version'),
+(3, 'idempotency_keys'),
+(4, 'audit_events'),
+(5, 'outbox'),
+(6, 'webhooks'),
+(7, 'user_changes'),
+(8, 'jobs'),
+(9, 'scheduled_tasks'),
+(10, 'rate_limit_buckets'),
+(11, 'users.email_canonical'),
+(12, 'idempotency_keys.principal')
+ON CONFLICT (version) DO NOTHING;
+
 INSERT INTO users (name, email) VALUES
 ('John Doe', 'john@example.com'),
-('Jane Smith', 'jane@example.com');
\ No newline at end of file
+('Jane Smith', 'jane@example.com');
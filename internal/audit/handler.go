@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/db"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/logging"
+)
+
+// ListAuditEvents handles GET /audit?user_id=&action=&actor=&from=&to=&page=&limit=.
+// from and to are RFC 3339 timestamps; to is exclusive. Only admins may read
+// the audit log.
+func ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if !auth.FromContext(r.Context()).HasRole(auth.RoleAdmin) {
+		httphelper.Error(w, http.StatusForbidden, "The audit log requires the admin role")
+		return
+	}
+
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+	opts := ListOptions{
+		Action: q.Get("action"),
+		Actor:  q.Get("actor"),
+		Limit:  limit,
+		Offset: (page - 1) * limit,
+	}
+
+	if v := q.Get("user_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			httphelper.Error(w, http.StatusBadRequest, "Invalid user_id")
+			return
+		}
+		opts.UserID = id
+	}
+	switch opts.Action {
+	case "", ActionCreate, ActionUpdate, ActionDelete, ActionRestore:
+	default:
+		httphelper.Error(w, http.StatusBadRequest, "invalid action: allowed create,update,delete,restore")
+		return
+	}
+	for name, dst := range map[string]*time.Time{"from": &opts.From, "to": &opts.To} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				httphelper.Error(w, http.StatusBadRequest, "Invalid "+name+": expected RFC 3339 timestamp")
+				return
+			}
+			*dst = t
+		}
+	}
+
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	events, total, err := ListEvents(r.Context(), reader, opts)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("list audit events failed", "error", err)
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch audit events")
+		return
+	}
+
+	httphelper.JSON(w, http.StatusOK, map[string]interface{}{
+		"page":        page,
+		"limit":       limit,
+		"total":       total,
+		"total_pages": (total + limit - 1) / limit,
+		"data":        events,
+	})
+}
@@ -0,0 +1,63 @@
+// Package audit keeps an append-only record of every user mutation: who did
+// it, from where, and which fields changed.
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+)
+
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+)
+
+// Change is the value of one field before and after a mutation.
+type Change struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+// Event is one row of the audit log.
+type Event struct {
+	ID         int64             `json:"id"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	Actor      string            `json:"actor"`
+	Action     string            `json:"action"`
+	UserID     int               `json:"user_id"`
+	Changes    map[string]Change `json:"changes"`
+	RequestID  string            `json:"request_id,omitempty"`
+	IP         string            `json:"ip,omitempty"`
+}
+
+// Entry describes a mutation to record. Before is nil for a create and After
+// is nil for a delete.
+type Entry struct {
+	Action string
+	UserID int
+	Before map[string]any
+	After  map[string]any
+}
+
+// Diff returns the fields whose value differs between before and after.
+func Diff(before, after map[string]any) map[string]Change {
+	changes := map[string]Change{}
+	for k, from := range before {
+		if to, ok := after[k]; !ok || !reflect.DeepEqual(from, to) {
+			changes[k] = Change{From: from, To: after[k]}
+		}
+	}
+	for k, to := range after {
+		if _, ok := before[k]; !ok {
+			changes[k] = Change{From: nil, To: to}
+		}
+	}
+	return changes
+}
+
+func (e Entry) changesJSON() ([]byte, error) {
+	return json.Marshal(Diff(e.Before, e.After))
+}
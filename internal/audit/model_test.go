@@ -0,0 +1,27 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	before := map[string]any{"name": "Ann", "email": "ann@example.com"}
+
+	assert.Equal(t, map[string]Change{
+		"email": {From: "ann@example.com", To: "ann@new.com"},
+	}, Diff(before, map[string]any{"name": "Ann", "email": "ann@new.com"}))
+
+	assert.Equal(t, map[string]Change{
+		"name":  {From: nil, To: "Ann"},
+		"email": {From: nil, To: "ann@example.com"},
+	}, Diff(nil, before), "Create should record every field")
+
+	assert.Equal(t, map[string]Change{
+		"name":  {From: "Ann", To: nil},
+		"email": {From: "ann@example.com", To: nil},
+	}, Diff(before, nil), "Delete should record every field")
+
+	assert.Empty(t, Diff(before, before))
+}
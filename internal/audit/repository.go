@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/db"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+)
+
+// recordChunkSize keeps multi-row INSERTs well below Postgres' 65535 parameter limit.
+const recordChunkSize = 1000
+
+// Record writes one audit event. Pass the transaction of the mutation so that
+// the event is committed or rolled back together with it. The actor, request
+// ID and client IP come from ctx.
+func Record(ctx context.Context, q db.DBTX, e Entry) error {
+	return RecordAll(ctx, q, []Entry{e})
+}
+
+// RecordAll writes one audit event per entry with multi-row INSERTs.
+func RecordAll(ctx context.Context, q db.DBTX, entries []Entry) error {
+	actor := auth.FromContext(ctx).Subject
+	requestID := nullString(httphelper.RequestIDFromContext(ctx))
+	ip := nullString(httphelper.ClientIPFromContext(ctx))
+
+	for start := 0; start < len(entries); start += recordChunkSize {
+		chunk := entries[start:min(start+recordChunkSize, len(entries))]
+		values := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*6)
+		for i, e := range chunk {
+			changes, err := e.changesJSON()
+			if err != nil {
+				return err
+			}
+			n := len(args)
+			values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6)
+			args = append(args, actor, e.Action, e.UserID, changes, requestID, ip)
+		}
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO audit_events (actor, action, user_id, changes, request_id, ip)
+			VALUES `+strings.Join(values, ", "), args...)
+		if err != nil {
+			return fmt.Errorf("record audit event: %w", err)
+		}
+	}
+	return nil
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// ListOptions filters the audit log. Zero values match everything.
+type ListOptions struct {
+	UserID int
+	Action string
+	Actor  string
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// ListEvents returns the events matching opt, newest first, and the total
+// number of matches.
+func ListEvents(ctx context.Context, q db.DBTX, opt ListOptions) ([]Event, int, error) {
+	defer metrics.ObserveQuery("list_audit_events", time.Now())
+	if opt.Limit <= 0 {
+		opt.Limit = 50
+	}
+
+	var conds []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if opt.UserID > 0 {
+		add("user_id = $%d", opt.UserID)
+	}
+	if opt.Action != "" {
+		add("action = $%d", opt.Action)
+	}
+	if opt.Actor != "" {
+		add("actor = $%d", opt.Actor)
+	}
+	if !opt.From.IsZero() {
+		add("occurred_at >= $%d", opt.From)
+	}
+	if !opt.To.IsZero() {
+		add("occurred_at < $%d", opt.To)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = "WHERE " + strings.Join(conds, " AND ")
+	}
+
+	var total int
+	if err := q.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	args = append(args, opt.Limit, opt.Offset)
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, occurred_at, actor, action, user_id, changes, COALESCE(request_id, ''), COALESCE(ip, '')
+		FROM audit_events %s
+		ORDER BY occurred_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []Event{}
+	for rows.Next() {
+		var e Event
+		var changes []byte
+		if err := rows.Scan(&e.ID, &e.OccurredAt, &e.Actor, &e.Action, &e.UserID, &changes, &e.RequestID, &e.IP); err != nil {
+			return nil, 0, err
+		}
+		if err := json.Unmarshal(changes, &e.Changes); err != nil {
+			return nil, 0, err
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordAndListEvents(t *testing.T) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("TRUNCATE audit_events")
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "user:7"})
+
+	err := RecordAll(ctx, conn, []Entry{
+		{Action: ActionCreate, UserID: 1, After: map[string]any{"email": "a@example.com"}},
+		{Action: ActionUpdate, UserID: 1, Before: map[string]any{"email": "a@example.com"}, After: map[string]any{"email": "b@example.com"}},
+		{Action: ActionCreate, UserID: 2, After: map[string]any{"email": "c@example.com"}},
+	})
+	assert.NoError(t, err)
+
+	events, total, err := ListEvents(ctx, conn, ListOptions{UserID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, ActionUpdate, events[0].Action, "Newest event should come first")
+	assert.Equal(t, "user:7", events[0].Actor)
+	assert.Equal(t, Change{From: "a@example.com", To: "b@example.com"}, events[0].Changes["email"])
+
+	_, total, err = ListEvents(ctx, conn, ListOptions{Action: ActionCreate, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, total)
+
+	_, total, err = ListEvents(ctx, conn, ListOptions{From: time.Now().Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, total)
+
+	_, err = conn.Exec("DELETE FROM audit_events")
+	assert.Error(t, err, "Audit events should be append-only")
+}
+
+func TestListAuditEventsRequiresAdmin(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ListAuditEvents(rec, httptest.NewRequest(http.MethodGet, "/audit", nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	req := httptest.NewRequest(http.MethodGet, "/audit?action=frobnicate", nil)
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Subject: "ops", Roles: []string{auth.RoleAdmin}}))
+	rec = httptest.NewRecorder()
+	ListAuditEvents(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
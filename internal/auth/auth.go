@@ -0,0 +1,88 @@
+// Package auth carries the authenticated principal of a request. The API sits
+// behind a gateway that authenticates callers and forwards who they are in
+// headers; requests without them, or not proven to come from the gateway, are
+// anonymous.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+)
+
+const (
+	SubjectHeader = "X-Auth-Subject"
+	RolesHeader   = "X-Auth-Roles"
+	// SecretHeader carries AUTH_GATEWAY_SECRET, proving the principal headers
+	// were set by the gateway rather than the client.
+	SecretHeader = "X-Auth-Gateway-Secret"
+
+	RoleAdmin = "admin"
+
+	// Anonymous is the subject of requests without a principal.
+	Anonymous = "anonymous"
+)
+
+// Principal is the caller of a request.
+type Principal struct {
+	Subject string
+	Roles   []string
+}
+
+// HasRole reports whether p was granted role.
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, or an anonymous one.
+func FromContext(ctx context.Context) Principal {
+	if p, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return p
+	}
+	return Principal{Subject: Anonymous}
+}
+
+// Middleware reads the principal forwarded by the gateway into the request
+// context. The headers are only honoured with AUTH_TRUST_HEADERS=true and a
+// request carrying AUTH_GATEWAY_SECRET; otherwise every caller is anonymous.
+func Middleware(next http.Handler) http.Handler {
+	trust := config.Bool("AUTH_TRUST_HEADERS", false)
+	secret := config.String("AUTH_GATEWAY_SECRET", "")
+	if trust && secret == "" {
+		slog.Error("AUTH_TRUST_HEADERS is set without AUTH_GATEWAY_SECRET, treating every request as anonymous")
+		trust = false
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := Principal{Subject: Anonymous}
+		if trust && subtle.ConstantTimeCompare([]byte(r.Header.Get(SecretHeader)), []byte(secret)) == 1 {
+			p = fromHeaders(r.Header)
+		}
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	})
+}
+
+// fromHeaders returns the principal named by the gateway headers in h.
+func fromHeaders(h http.Header) Principal {
+	p := Principal{Subject: strings.TrimSpace(h.Get(SubjectHeader))}
+	if p.Subject == "" {
+		p.Subject = Anonymous
+	}
+	for _, role := range strings.Split(h.Get(RolesHeader), ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			p.Roles = append(p.Roles, role)
+		}
+	}
+	return p
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Setenv("AUTH_TRUST_HEADERS", "true")
+	t.Setenv("AUTH_GATEWAY_SECRET", "gateway")
+	var got Principal
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(SecretHeader, "gateway")
+	req.Header.Set(SubjectHeader, "user:42")
+	req.Header.Set(RolesHeader, "admin, support")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "user:42", got.Subject)
+	assert.True(t, got.HasRole(RoleAdmin))
+	assert.True(t, got.HasRole("support"))
+
+	req.Header.Set(SecretHeader, "guess")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, Anonymous, got.Subject, "A wrong gateway secret should not be trusted")
+	assert.Empty(t, got.Roles)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, Anonymous, got.Subject)
+	assert.Empty(t, got.Roles)
+
+	assert.Equal(t, Anonymous, FromContext(context.Background()).Subject)
+}
+
+func TestMiddlewareIgnoresHeadersByDefault(t *testing.T) {
+	t.Setenv("AUTH_TRUST_HEADERS", "")
+	t.Setenv("AUTH_GATEWAY_SECRET", "gateway")
+	var got Principal
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(SecretHeader, "gateway")
+	req.Header.Set(SubjectHeader, "user:42")
+	req.Header.Set(RolesHeader, RoleAdmin)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, Anonymous, got.Subject, "Headers should be ignored unless AUTH_TRUST_HEADERS is on")
+	assert.False(t, got.HasRole(RoleAdmin))
+
+	t.Setenv("AUTH_TRUST_HEADERS", "true")
+	t.Setenv("AUTH_GATEWAY_SECRET", "")
+	handler = Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromContext(r.Context())
+	}))
+	req.Header.Del(SecretHeader)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, Anonymous, got.Subject, "Trusting headers without a secret should not be possible")
+}
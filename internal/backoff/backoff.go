@@ -0,0 +1,67 @@
+// Package backoff computes exponential retry delays with jitter.
+package backoff
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// Policy describes an exponential backoff: the delay before retry n is
+// Initial*Multiplier^n capped at Max, reduced by a random fraction up to Jitter.
+type Policy struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+	Jitter     float64
+}
+
+// Default is a reasonable policy for reconnecting to infrastructure.
+var Default = Policy{Initial: 500 * time.Millisecond, Max: 10 * time.Second, Multiplier: 2, Jitter: 0.5}
+
+// Delay returns how long to wait before retry number attempt, counting from 0.
+func (p Policy) Delay(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+	d := float64(p.Initial) * math.Pow(mult, float64(attempt))
+	if p.Max > 0 && d > float64(p.Max) {
+		d = float64(p.Max)
+	}
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// Sleep waits for d or until ctx is done, whichever comes first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// Retry calls fn until it succeeds, attempts calls were made or ctx is done,
+// sleeping according to p in between. It returns the last error from fn.
+func Retry(ctx context.Context, p Policy, attempts int, fn func(ctx context.Context, attempt int) error) error {
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if err = fn(ctx, attempt); err == nil {
+			return nil
+		}
+		if attempt == attempts-1 {
+			break
+		}
+		if sleepErr := Sleep(ctx, p.Delay(attempt)); sleepErr != nil {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,56 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelay(t *testing.T) {
+	p := Policy{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	assert.Equal(t, 100*time.Millisecond, p.Delay(0))
+	assert.Equal(t, 400*time.Millisecond, p.Delay(2))
+	assert.Equal(t, time.Second, p.Delay(10), "Delay should be capped at Max")
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.Delay(3)
+		assert.GreaterOrEqual(t, d, 400*time.Millisecond)
+		assert.LessOrEqual(t, d, 800*time.Millisecond)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	p := Policy{Initial: time.Millisecond, Max: time.Millisecond}
+	calls := 0
+	err := Retry(context.Background(), p, 5, func(context.Context, int) error {
+		calls++
+		if calls < 3 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = Retry(context.Background(), p, 4, func(context.Context, int) error {
+		calls++
+		return errors.New("down")
+	})
+	assert.EqualError(t, err, "down")
+	assert.Equal(t, 4, calls)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = 0
+	err = Retry(ctx, Policy{Initial: time.Hour}, 4, func(context.Context, int) error {
+		calls++
+		return errors.New("down")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls, "Cancelled context should stop retrying")
+}
@@ -0,0 +1,152 @@
+// Package breaker implements a circuit breaker. After Threshold consecutive
+// failures it opens and rejects calls for OpenFor; then it lets a single probe
+// through and closes again if the probe succeeds.
+package breaker
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/metrics"
+)
+
+// ErrOpen is returned for calls rejected while the breaker is open.
+var ErrOpen = errors.New("circuit breaker open")
+
+type State int
+
+const (
+	Closed State = iota
+	Open
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// Breaker counts consecutive failures of the calls it admits.
+type Breaker struct {
+	name      string
+	threshold int
+	openFor   time.Duration
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	until    time.Time // end of the open period
+	probing  bool
+	// generation changes on every transition so that calls admitted in an
+	// earlier state do not decide the current one
+	generation uint64
+	now        func() time.Time
+}
+
+// New returns a closed breaker; name labels its metrics and logs.
+func New(name string, threshold int, openFor time.Duration) *Breaker {
+	metrics.BreakerState.WithLabelValues(name).Set(float64(Closed))
+	return &Breaker{name: name, threshold: max(threshold, 1), openFor: openFor, now: time.Now}
+}
+
+// Allow admits a call or returns ErrOpen. The caller must report the outcome
+// of an admitted call through done.
+func (b *Breaker) Allow() (done func(failed bool), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if b.now().Before(b.until) {
+			metrics.BreakerRejected.WithLabelValues(b.name).Inc()
+			return nil, ErrOpen
+		}
+		b.transition(HalfOpen)
+		fallthrough
+	case HalfOpen:
+		if b.probing {
+			metrics.BreakerRejected.WithLabelValues(b.name).Inc()
+			return nil, ErrOpen
+		}
+		b.probing = true
+	}
+	generation := b.generation
+	return func(failed bool) { b.record(generation, failed) }, nil
+}
+
+// Check reports whether a call would currently be admitted without
+// admitting one.
+func (b *Breaker) Check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if (b.state == Open && b.now().Before(b.until)) || (b.state == HalfOpen && b.probing) {
+		return ErrOpen
+	}
+	return nil
+}
+
+// State returns the current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// RetryAfter returns how long the breaker stays open, or 0 if it is not.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return 0
+	}
+	return max(b.until.Sub(b.now()), 0)
+}
+
+func (b *Breaker) record(generation uint64, failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if generation != b.generation {
+		return
+	}
+	switch {
+	case b.state == HalfOpen && failed:
+		b.trip()
+	case b.state == HalfOpen:
+		b.transition(Closed)
+	case failed:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.trip()
+		}
+	default:
+		b.failures = 0
+	}
+}
+
+// trip opens the breaker; mu must be held.
+func (b *Breaker) trip() {
+	b.until = b.now().Add(b.openFor)
+	b.transition(Open)
+}
+
+// transition moves to state; mu must be held.
+func (b *Breaker) transition(state State) {
+	switch state {
+	case Open:
+		slog.Warn("circuit breaker opened", "breaker", b.name, "from", b.state.String(), "open_for", b.openFor)
+	case Closed:
+		slog.Info("circuit breaker closed", "breaker", b.name)
+	}
+	b.state = state
+	b.failures = 0
+	b.probing = false
+	b.generation++
+	metrics.BreakerState.WithLabelValues(b.name).Set(float64(state))
+}
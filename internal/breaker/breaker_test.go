@@ -0,0 +1,76 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func call(t *testing.T, b *Breaker, failed bool) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	done(failed)
+	return nil
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := New("test", 3, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	require.NoError(t, call(t, b, true))
+	require.NoError(t, call(t, b, true))
+	require.NoError(t, call(t, b, false))
+	require.NoError(t, call(t, b, true))
+	assert.Equal(t, Closed, b.State(), "A success should reset the failure count")
+
+	require.NoError(t, call(t, b, true))
+	require.NoError(t, call(t, b, true))
+	assert.Equal(t, Open, b.State())
+	assert.ErrorIs(t, call(t, b, false), ErrOpen)
+	assert.ErrorIs(t, b.Check(), ErrOpen)
+	assert.Equal(t, 10*time.Second, b.RetryAfter())
+
+	now = now.Add(4 * time.Second)
+	assert.Equal(t, 6*time.Second, b.RetryAfter())
+}
+
+func TestBreakerHalfOpenProbe(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := New("test", 1, 10*time.Second)
+	b.now = func() time.Time { return now }
+	require.NoError(t, call(t, b, true))
+	assert.Equal(t, Open, b.State())
+
+	now = now.Add(10 * time.Second)
+	assert.NoError(t, b.Check())
+	probe, err := b.Allow()
+	require.NoError(t, err)
+	assert.Equal(t, HalfOpen, b.State())
+	_, err = b.Allow()
+	assert.ErrorIs(t, err, ErrOpen, "Only one probe should run at a time")
+
+	probe(true)
+	assert.Equal(t, Open, b.State(), "A failed probe should reopen the breaker")
+	assert.Equal(t, 10*time.Second, b.RetryAfter())
+
+	now = now.Add(10 * time.Second)
+	require.NoError(t, call(t, b, false))
+	assert.Equal(t, Closed, b.State(), "A successful probe should close the breaker")
+	assert.Zero(t, b.RetryAfter())
+}
+
+func TestBreakerIgnoresCallsFromEarlierStates(t *testing.T) {
+	b := New("test", 1, time.Minute)
+	slow, err := b.Allow()
+	require.NoError(t, err)
+	require.NoError(t, call(t, b, true))
+	assert.Equal(t, Open, b.State())
+
+	slow(false)
+	assert.Equal(t, Open, b.State(), "A call admitted before the breaker opened should not close it")
+}
@@ -0,0 +1,101 @@
+// Package cache is a read-through cache for values that are expensive to load.
+// Values are stored JSON-encoded in a Store: an in-process LRU, or Redis when
+// the instances should share one. Concurrent misses for the same key share a
+// single load, so a hot key that expires does not stampede the database.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/metrics"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Store keeps encoded values until they expire.
+type Store interface {
+	// Get returns the value stored under key and whether there was one.
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	// Clear drops every value.
+	Clear(ctx context.Context) error
+}
+
+// Cache reads values of type V through a Store. Its name labels the metrics.
+type Cache[V any] struct {
+	name  string
+	store Store
+	ttl   time.Duration
+	group singleflight.Group
+
+	// epoch counts invalidations; a load only stores its value if none
+	// happened while it ran, since the value may predate the change
+	epoch atomic.Uint64
+}
+
+func New[V any](name string, store Store, ttl time.Duration) *Cache[V] {
+	return &Cache[V]{name: name, store: store, ttl: ttl}
+}
+
+// Get returns the value cached under key, or calls load and caches what it
+// returns. Errors are not cached. A failing store only costs the load: its
+// errors are logged and count as misses.
+func (c *Cache[V]) Get(ctx context.Context, key string, load func(ctx context.Context) (V, error)) (V, error) {
+	data, ok, err := c.store.Get(ctx, key)
+	if err != nil {
+		slog.Warn("cache read failed", "cache", c.name, "error", err)
+		metrics.CacheRequests.WithLabelValues(c.name, "error").Inc()
+	} else if ok {
+		var v V
+		if err := json.Unmarshal(data, &v); err == nil {
+			metrics.CacheRequests.WithLabelValues(c.name, "hit").Inc()
+			return v, nil
+		}
+		slog.Warn("cached value undecodable, reloading", "cache", c.name, "key", key)
+	}
+	if err == nil {
+		metrics.CacheRequests.WithLabelValues(c.name, "miss").Inc()
+	}
+
+	v, err, _ := c.group.Do(key, func() (any, error) {
+		metrics.CacheLoads.WithLabelValues(c.name).Inc()
+		epoch := c.epoch.Load()
+		// the load is shared, so one caller giving up must not fail the others
+		v, err := load(context.WithoutCancel(ctx))
+		if err != nil {
+			return v, err
+		}
+		if c.epoch.Load() == epoch {
+			c.set(ctx, key, v)
+		}
+		return v, nil
+	})
+	return v.(V), err
+}
+
+func (c *Cache[V]) set(ctx context.Context, key string, v V) {
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = c.store.Set(context.WithoutCancel(ctx), key, data, c.ttl)
+	}
+	if err != nil {
+		slog.Warn("cache write failed", "cache", c.name, "error", err)
+	}
+}
+
+// Delete drops the values of keys, e.g. after the data behind them changed.
+func (c *Cache[V]) Delete(ctx context.Context, keys ...string) error {
+	c.epoch.Add(1)
+	return c.store.Delete(ctx, keys...)
+}
+
+// Clear drops every value.
+func (c *Cache[V]) Clear(ctx context.Context) error {
+	c.epoch.Add(1)
+	return c.store.Clear(ctx)
+}
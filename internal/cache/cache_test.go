@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type item struct {
+	Name string `json:"name"`
+}
+
+func TestLRU(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	lru := NewLRU(2)
+	lru.now = func() time.Time { return now }
+
+	_ = lru.Set(ctx, "a", []byte("1"), time.Minute)
+	_ = lru.Set(ctx, "b", []byte("2"), time.Minute)
+	_, ok, _ := lru.Get(ctx, "a") // a is now the most recently used
+	assert.True(t, ok)
+	_ = lru.Set(ctx, "c", []byte("3"), time.Minute)
+
+	_, ok, _ = lru.Get(ctx, "b")
+	assert.False(t, ok, "Least recently used value should be evicted")
+	value, ok, _ := lru.Get(ctx, "a")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1"), value)
+	assert.Equal(t, 2, lru.Len())
+
+	now = now.Add(time.Minute)
+	_, ok, _ = lru.Get(ctx, "c")
+	assert.False(t, ok, "Expired values should not be returned")
+	assert.Equal(t, 1, lru.Len(), "Expired values should be dropped on read")
+
+	_ = lru.Delete(ctx, "a", "missing")
+	assert.Equal(t, 0, lru.Len())
+
+	_ = lru.Set(ctx, "d", []byte("4"), time.Minute)
+	_ = lru.Clear(ctx)
+	assert.Equal(t, 0, lru.Len())
+}
+
+func TestCacheReadThrough(t *testing.T) {
+	ctx := context.Background()
+	c := New[item]("test", NewLRU(10), time.Minute)
+	var loads int
+	load := func(ctx context.Context) (item, error) {
+		loads++
+		return item{Name: "ada"}, nil
+	}
+
+	v, err := c.Get(ctx, "k", load)
+	require.NoError(t, err)
+	assert.Equal(t, "ada", v.Name)
+	v, _ = c.Get(ctx, "k", load)
+	assert.Equal(t, "ada", v.Name)
+	assert.Equal(t, 1, loads, "Second read should be a hit")
+
+	require.NoError(t, c.Delete(ctx, "k"))
+	_, _ = c.Get(ctx, "k", load)
+	assert.Equal(t, 2, loads, "Deleted values should be reloaded")
+
+	_, err = c.Get(ctx, "fails", func(ctx context.Context) (item, error) { return item{}, errors.New("boom") })
+	assert.EqualError(t, err, "boom")
+	_, err = c.Get(ctx, "fails", load)
+	assert.NoError(t, err, "Errors should not be cached")
+}
+
+func TestCacheSharesConcurrentLoads(t *testing.T) {
+	ctx := context.Background()
+	c := New[item]("test", NewLRU(10), time.Minute)
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func(ctx context.Context) (item, error) {
+		loads.Add(1)
+		<-release
+		return item{Name: "ada"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.Get(ctx, "hot", load)
+			assert.NoError(t, err)
+			assert.Equal(t, "ada", v.Name)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), loads.Load(), "Concurrent misses should share one load")
+}
+
+func TestCacheDropsLoadsOverlappingInvalidation(t *testing.T) {
+	ctx := context.Background()
+	store := NewLRU(10)
+	c := New[item]("test", store, time.Minute)
+
+	v, err := c.Get(ctx, "k", func(ctx context.Context) (item, error) {
+		// the value changes while it is being loaded
+		_ = c.Delete(ctx, "k")
+		return item{Name: "stale"}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "stale", v.Name)
+	_, ok, _ := store.Get(ctx, "k")
+	assert.False(t, ok, "A load that overlapped an invalidation should not be stored")
+}
+
+type brokenStore struct{ *LRU }
+
+func (brokenStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, errors.New("store down")
+}
+
+func TestCacheFallsBackWhenStoreFails(t *testing.T) {
+	c := New[item]("test", brokenStore{NewLRU(10)}, time.Minute)
+	v, err := c.Get(context.Background(), "k", func(ctx context.Context) (item, error) {
+		return item{Name: "ada"}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "ada", v.Name)
+}
@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// LRU keeps up to size values in process memory and evicts the least recently
+// used one when full. Every instance has its own, so changes made elsewhere
+// must be invalidated explicitly.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List // front is the most recently used
+	now   func() time.Time
+}
+
+func NewLRU(size int) *LRU {
+	return &LRU{size: max(size, 1), items: map[string]*list.Element{}, order: list.New(), now: time.Now}
+}
+
+func (l *LRU) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	el, ok := l.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*lruEntry)
+	if !l.now().Before(e.expires) {
+		l.remove(el)
+		return nil, false, nil
+	}
+	l.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+func (l *LRU) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	expires := l.now().Add(ttl)
+	if el, ok := l.items[key]; ok {
+		e := el.Value.(*lruEntry)
+		e.value, e.expires = value, expires
+		l.order.MoveToFront(el)
+		return nil
+	}
+	l.items[key] = l.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for l.order.Len() > l.size {
+		l.remove(l.order.Back())
+	}
+	return nil
+}
+
+func (l *LRU) Delete(ctx context.Context, keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := l.items[key]; ok {
+			l.remove(el)
+		}
+	}
+	return nil
+}
+
+func (l *LRU) Clear(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.items)
+	l.order.Init()
+	return nil
+}
+
+// Len returns the number of values held, expired ones included.
+func (l *LRU) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.order.Len()
+}
+
+func (l *LRU) remove(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.items, el.Value.(*lruEntry).key)
+}
@@ -0,0 +1,39 @@
+// Package changefeed streams changes to the users table to clients over
+// Server-Sent Events. A trigger records every change in user_changes and
+// announces it with NOTIFY, so every API instance sees every change no matter
+// which instance made it.
+package changefeed
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+)
+
+// Channel is the NOTIFY channel the users trigger publishes change IDs on.
+const Channel = "user_changes"
+
+const (
+	OpCreate  = "create"
+	OpUpdate  = "update"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+)
+
+// Change is one row of user_changes. User is the state of the user after the
+// change (before it, for hard deletes).
+type Change struct {
+	ID        int64           `json:"id"`
+	Op        string          `json:"op"`
+	UserID    int             `json:"user_id"`
+	User      json.RawMessage `json:"user"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+// Visible reports whether p may see c: admins see every change, everyone else
+// only changes to the user they are.
+func Visible(p auth.Principal, c Change) bool {
+	return p.HasRole(auth.RoleAdmin) || p.Subject == strconv.Itoa(c.UserID)
+}
@@ -0,0 +1,131 @@
+package changefeed
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/db"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/logging"
+)
+
+// Stream handles GET /users/changes, a text/event-stream of the user changes
+// the caller may see (see Visible). Each event carries the change ID, so a
+// client reconnecting with Last-Event-ID (or ?last_event_id=) first receives
+// what it missed. Without it the stream starts with the next change.
+func (h *Hub) Stream(w http.ResponseWriter, r *http.Request) {
+	p := auth.FromContext(r.Context())
+	if p.Subject == auth.Anonymous {
+		httphelper.Error(w, http.StatusUnauthorized, "The change feed requires an authenticated caller")
+		return
+	}
+
+	var lastEventID int64 = -1
+	v := r.Header.Get("Last-Event-ID")
+	if v == "" {
+		v = r.URL.Query().Get("last_event_id")
+	}
+	if v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id < 0 {
+			httphelper.Error(w, http.StatusBadRequest, "Invalid Last-Event-ID")
+			return
+		}
+		lastEventID = id
+	}
+
+	// subscribe before catching up so nothing falls between the two
+	changes, unsubscribe := h.Subscribe()
+	defer unsubscribe()
+
+	var missed []Change
+	if lastEventID >= 0 {
+		database, err := db.Connect()
+		if err != nil {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+			return
+		}
+		for after := lastEventID; ; {
+			batch, err := ChangesSince(r.Context(), database, after, catchUpBatch)
+			if err != nil {
+				httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch user changes")
+				return
+			}
+			missed = append(missed, batch...)
+			if len(batch) < catchUpBatch {
+				break
+			}
+			after = batch[len(batch)-1].ID
+		}
+	}
+
+	// the server's write timeout would cut the stream off
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(c Change) error {
+		if !Visible(p, c) {
+			return nil
+		}
+		data, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.ID, c.Op, data); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if _, err := fmt.Fprint(w, "retry: 3000\n\n"); err != nil {
+		return
+	}
+	seen := make(map[int64]bool, len(missed))
+	for _, c := range missed {
+		if err := send(c); err != nil {
+			return
+		}
+		seen[c.ID] = true
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(config.Duration("USER_CHANGES_HEARTBEAT", 15*time.Second))
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case c, ok := <-changes:
+			if !ok {
+				return
+			}
+			if seen[c.ID] {
+				continue
+			}
+			if err := send(c); err != nil {
+				logging.FromContext(r.Context()).Debug("user change stream closed", "error", err)
+				return
+			}
+		case <-heartbeat.C:
+			// a comment keeps proxies from closing an idle connection
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
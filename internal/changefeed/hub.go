@@ -0,0 +1,184 @@
+package changefeed
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+
+	"github.com/lib/pq"
+)
+
+// subscriberBuffer is how many changes a stream may fall behind before the hub
+// drops it; the client reconnects with Last-Event-ID and catches up from the
+// database.
+const subscriberBuffer = 256
+
+// catchUpBatch bounds the changes read per query when catching up.
+const catchUpBatch = 500
+
+// Hub listens for change notifications and fans them out to the open streams
+// of this instance.
+type Hub struct {
+	mu     sync.Mutex
+	subs   map[chan Change]struct{}
+	closed bool
+
+	// lastID is the newest change broadcast, or -1 before the first lookup;
+	// only the Run goroutine touches it
+	lastID int64
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: map[chan Change]struct{}{}, lastID: -1}
+}
+
+// Subscribe returns a channel receiving every change broadcast from now on and
+// a function that unsubscribes it. The channel is closed when the subscriber
+// falls too far behind or the hub is closed.
+func (h *Hub) Subscribe() (<-chan Change, func()) {
+	ch := make(chan Change, subscriberBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends every stream and refuses new ones. The server calls it on
+// shutdown because open streams would otherwise keep it waiting.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *Hub) broadcast(c Change) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- c:
+		default:
+			slog.Warn("change stream too slow, disconnecting", "change_id", c.ID)
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Run listens on Channel and broadcasts every change until ctx is done. When
+// the listener reconnects it catches up from the last change it broadcast.
+func (h *Hub) Run(ctx context.Context, pingInterval time.Duration) {
+	listener, err := db.NewListener(time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventDisconnected:
+			slog.Warn("change listener disconnected", "error", err)
+		case pq.ListenerEventReconnected:
+			slog.Info("change listener reconnected")
+		}
+	})
+	if err != nil {
+		slog.Error("user change feed disabled", "error", err)
+		return
+	}
+	// closing the listener also ends a Listen still waiting for the first connection
+	defer listener.Close()
+	listening := make(chan error, 1)
+	go func() { listening <- listener.Listen(Channel) }()
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-listening:
+			// after an error the channel is still listened on once the
+			// connection is back
+			if err != nil {
+				slog.Warn("listen for user changes", "error", err)
+			}
+			h.catchUp(ctx)
+		case n := <-listener.Notify:
+			if n == nil {
+				h.catchUp(ctx)
+				continue
+			}
+			id, err := strconv.ParseInt(n.Extra, 10, 64)
+			if err != nil {
+				slog.Warn("malformed user change notification", "payload", n.Extra)
+				continue
+			}
+			h.deliver(ctx, id)
+		case <-ticker.C:
+			// notices a dead connection that would otherwise go unnoticed
+			go func() { _ = listener.Ping() }()
+		}
+	}
+}
+
+// deliver broadcasts the change with the notified id. Transactions may commit
+// out of ID order, so the change is fetched by ID rather than as "everything
+// after lastID".
+func (h *Hub) deliver(ctx context.Context, id int64) {
+	database, err := db.Connect()
+	if err != nil {
+		return
+	}
+	c, err := GetChange(ctx, database, id)
+	if err != nil {
+		slog.Error("load user change", "change_id", id, "error", err)
+		return
+	}
+	h.broadcast(c)
+	h.lastID = max(h.lastID, c.ID)
+}
+
+// catchUp broadcasts the changes made after lastID, which were missed while
+// the listener was disconnected. The first call only records where the feed
+// starts.
+func (h *Hub) catchUp(ctx context.Context) {
+	database, err := db.Connect()
+	if err != nil {
+		return
+	}
+	if h.lastID < 0 {
+		if h.lastID, err = LatestChangeID(ctx, database); err != nil {
+			h.lastID = -1
+			slog.Error("load latest user change", "error", err)
+		}
+		return
+	}
+	for {
+		changes, err := ChangesSince(ctx, database, h.lastID, catchUpBatch)
+		if err != nil {
+			slog.Error("catch up on user changes", "error", err)
+			return
+		}
+		for _, c := range changes {
+			h.broadcast(c)
+			h.lastID = c.ID
+		}
+		if len(changes) < catchUpBatch {
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package changefeed
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubBroadcast(t *testing.T) {
+	h := NewHub()
+	a, unsubscribeA := h.Subscribe()
+	b, _ := h.Subscribe()
+
+	h.broadcast(Change{ID: 1, Op: OpCreate, UserID: 7})
+	assert.Equal(t, int64(1), (<-a).ID)
+	assert.Equal(t, int64(1), (<-b).ID)
+
+	unsubscribeA()
+	_, ok := <-a
+	assert.False(t, ok, "Unsubscribing should close the channel")
+	unsubscribeA()
+
+	h.Close()
+	_, ok = <-b
+	assert.False(t, ok, "Closing the hub should end every stream")
+	c, _ := h.Subscribe()
+	_, ok = <-c
+	assert.False(t, ok, "A closed hub should refuse new streams")
+}
+
+func TestHubDropsSlowSubscribers(t *testing.T) {
+	h := NewHub()
+	slow, _ := h.Subscribe()
+	for i := 0; i <= subscriberBuffer; i++ {
+		h.broadcast(Change{ID: int64(i + 1)})
+	}
+
+	n := 0
+	for range slow {
+		n++
+	}
+	assert.Equal(t, subscriberBuffer, n, "The buffered changes are delivered before the channel closes")
+}
+
+func TestVisible(t *testing.T) {
+	c := Change{UserID: 7}
+	assert.True(t, Visible(auth.Principal{Subject: "ops", Roles: []string{auth.RoleAdmin}}, c))
+	assert.True(t, Visible(auth.Principal{Subject: "7"}, c))
+	assert.False(t, Visible(auth.Principal{Subject: "8"}, c))
+}
+
+func TestStream(t *testing.T) {
+	t.Setenv("AUTH_TRUST_HEADERS", "true")
+	t.Setenv("AUTH_GATEWAY_SECRET", "gateway")
+	h := NewHub()
+	srv := httptest.NewServer(auth.Middleware(http.HandlerFunc(h.Stream)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	req.Header.Set(auth.SecretHeader, "gateway")
+	req.Header.Set(auth.SubjectHeader, "7")
+	resp, err = http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// wait for the stream to subscribe before publishing
+	for {
+		h.mu.Lock()
+		n := len(h.subs)
+		h.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	h.broadcast(Change{ID: 10, Op: OpUpdate, UserID: 8, User: json.RawMessage(`{"id":8}`)})
+	h.broadcast(Change{ID: 11, Op: OpUpdate, UserID: 7, User: json.RawMessage(`{"id":7}`)})
+
+	reader := bufio.NewReader(resp.Body)
+	var event []string
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" && len(event) > 0 && strings.HasPrefix(event[0], "id:") {
+			break
+		}
+		if line == "" {
+			event = nil
+			continue
+		}
+		event = append(event, line)
+	}
+	assert.Equal(t, "id: 11", event[0], "Changes to other users should be filtered out")
+	assert.Equal(t, "event: update", event[1])
+	assert.Contains(t, event[2], `"user_id":7`)
+}
@@ -0,0 +1,62 @@
+package changefeed
+
+import (
+	"context"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+)
+
+const changeColumns = "id, op, user_id, data, changed_at"
+
+func GetChange(ctx context.Context, q db.DBTX, id int64) (Change, error) {
+	defer metrics.ObserveQuery("get_user_change", time.Now())
+	var c Change
+	err := q.QueryRowContext(ctx, "SELECT "+changeColumns+" FROM user_changes WHERE id = $1", id).
+		Scan(&c.ID, &c.Op, &c.UserID, &c.User, &c.ChangedAt)
+	return c, err
+}
+
+// ChangesSince returns up to limit changes after afterID in ID order.
+func ChangesSince(ctx context.Context, q db.DBTX, afterID int64, limit int) ([]Change, error) {
+	defer metrics.ObserveQuery("list_user_changes", time.Now())
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+changeColumns+` FROM user_changes
+		WHERE id > $1 ORDER BY id LIMIT $2
+	`, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []Change
+	for rows.Next() {
+		var c Change
+		if err := rows.Scan(&c.ID, &c.Op, &c.UserID, &c.User, &c.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	return changes, rows.Err()
+}
+
+// LatestChangeID returns the ID of the newest change, or 0 if there is none.
+func LatestChangeID(ctx context.Context, q db.DBTX) (int64, error) {
+	var id int64
+	err := q.QueryRowContext(ctx, "SELECT COALESCE(MAX(id), 0) FROM user_changes").Scan(&id)
+	return id, err
+}
+
+// PurgeChanges deletes changes older than olderThan; clients cannot resume
+// from before that point any more. It returns how many were removed.
+func PurgeChanges(ctx context.Context, q db.DBTX, olderThan time.Duration) (int64, error) {
+	result, err := q.ExecContext(ctx, `
+		DELETE FROM user_changes
+		WHERE changed_at < NOW() - MAKE_INTERVAL(secs => $1)
+	`, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,79 @@
+// Package config reads application settings from the environment, loading
+// the .env file in the project root first when there is one.
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+var once sync.Once
+
+// Load reads the .env file once. Variables already set in the environment win.
+func Load() {
+	once.Do(func() {
+		// Load environment variables look for .env file in the project root
+		env := filepath.Join(ProjectRoot(), ".env")
+		if err := godotenv.Load(env); err != nil {
+			slog.Info("no .env file loaded", "path", env, "error", err)
+		}
+	})
+}
+
+// String returns the value of key, or def when it is unset or empty.
+func String(key, def string) string {
+	Load()
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// Int returns the integer value of key, or def when it is unset or invalid.
+func Int(key string, def int) int {
+	v, err := strconv.Atoi(String(key, ""))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Bool returns the boolean value of key, or def when it is unset or invalid.
+func Bool(key string, def bool) bool {
+	v, err := strconv.ParseBool(String(key, ""))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Duration returns the value of key parsed with time.ParseDuration, or def.
+func Duration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(String(key, ""))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// ProjectRoot walks up from the working directory to the folder holding go.mod.
+func ProjectRoot() string {
+	dir, _ := os.Getwd()
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return ""
+}
@@ -0,0 +1,223 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/breaker"
+
+	"github.com/lib/pq"
+)
+
+// ErrUnavailable is returned by Connect while the circuit breaker around the
+// primary is open.
+var ErrUnavailable = errors.New("database unavailable: circuit breaker open")
+
+// Unavailable reports whether err means the database could not serve the
+// request at all, so the client should get 503 rather than 500.
+func Unavailable(err error) bool {
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, breaker.ErrOpen) || isOutage(err)
+}
+
+// isOutage reports whether err says the database is in trouble, as opposed
+// to an error in the statement or a client that went away.
+func isOutage(err error) bool {
+	switch {
+	case err == nil, errors.Is(err, context.Canceled), errors.Is(err, breaker.ErrOpen):
+		return false
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, driver.ErrBadConn), errors.Is(err, io.EOF):
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		case "08", // connection exception
+			"53", // insufficient resources
+			"57", // operator intervention, including statement timeouts
+			"58": // system error
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// guard passes the driver calls of a pool through a circuit breaker. Outages
+// and calls slower than slow count as failures.
+type guard struct {
+	breaker *breaker.Breaker
+	slow    time.Duration
+}
+
+func (g guard) do(fn func() error) error {
+	done, err := g.breaker.Allow()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	err = fn()
+	done(isOutage(err) || (g.slow > 0 && time.Since(start) > g.slow))
+	return err
+}
+
+type guardedConnector struct {
+	driver.Connector
+	guard guard
+}
+
+func (c guardedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	err := c.guard.do(func() (err error) {
+		conn, err = c.Connector.Connect(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &guardedConn{conn: conn, guard: c.guard}, nil
+}
+
+// guardedConn wraps a pq connection. Statements, transactions and rows it
+// returns are pq's own; only the calls that go to the server are guarded.
+type guardedConn struct {
+	conn  driver.Conn
+	guard guard
+}
+
+func (c *guardedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *guardedConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	err = c.guard.do(func() error {
+		if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+			stmt, err = p.PrepareContext(ctx, query)
+		} else {
+			stmt, err = c.conn.Prepare(query)
+		}
+		return err
+	})
+	return stmt, err
+}
+
+func (c *guardedConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *guardedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *guardedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx driver.Tx, err error) {
+	err = c.guard.do(func() error {
+		if b, ok := c.conn.(driver.ConnBeginTx); ok {
+			tx, err = b.BeginTx(ctx, opts)
+		} else {
+			tx, err = c.conn.Begin()
+		}
+		return err
+	})
+	return tx, err
+}
+
+func (c *guardedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	q, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	err = c.guard.do(func() error {
+		rows, err = q.QueryContext(ctx, query, args)
+		return err
+	})
+	return rows, err
+}
+
+func (c *guardedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (result driver.Result, err error) {
+	e, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	err = c.guard.do(func() error {
+		result, err = e.ExecContext(ctx, query, args)
+		return err
+	})
+	return result, err
+}
+
+func (c *guardedConn) Ping(ctx context.Context) error {
+	p, ok := c.conn.(driver.Pinger)
+	if !ok {
+		return nil
+	}
+	return c.guard.do(func() error { return p.Ping(ctx) })
+}
+
+func (c *guardedConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *guardedConn) IsValid() bool {
+	if v, ok := c.conn.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+// RetryAfter adds a Retry-After header to 503 responses while the circuit
+// breaker around the primary is open, telling clients when to come back.
+func RetryAfter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&retryAfterWriter{ResponseWriter: w}, r)
+	})
+}
+
+type retryAfterWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *retryAfterWriter) WriteHeader(status int) {
+	if !w.wroteHeader && status == http.StatusServiceUnavailable && w.Header().Get("Retry-After") == "" {
+		if wait := BreakerRetryAfter(); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		}
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *retryAfterWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *retryAfterWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *retryAfterWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// BreakerRetryAfter returns how long the circuit breaker around the primary
+// stays open, or 0 if it is not open.
+func BreakerRetryAfter() time.Duration {
+	once.Do(open)
+	if primaryBreaker == nil {
+		return 0
+	}
+	return primaryBreaker.RetryAfter()
+}
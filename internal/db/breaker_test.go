@@ -0,0 +1,85 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/breaker"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsOutage(t *testing.T) {
+	outages := []error{
+		context.DeadlineExceeded,
+		driver.ErrBadConn,
+		&net.OpError{Op: "dial", Err: errors.New("connection refused")},
+		&pq.Error{Code: "08006"},
+		&pq.Error{Code: "53300"},
+		fmt.Errorf("query: %w", &pq.Error{Code: "57014"}),
+	}
+	for _, err := range outages {
+		assert.True(t, isOutage(err), "%v", err)
+	}
+	for _, err := range []error{nil, context.Canceled, breaker.ErrOpen, sql.ErrNoRows, &pq.Error{Code: "23505"}} {
+		assert.False(t, isOutage(err), "%v", err)
+	}
+}
+
+type failingConnector struct {
+	fakeConnector
+	err   error
+	calls int
+}
+
+func (c *failingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.fakeConnector.Connect(ctx)
+}
+
+func TestGuardedConnector(t *testing.T) {
+	ctx := context.Background()
+	connector := &failingConnector{fakeConnector: fakeConnector{&fakeDriver{}}, err: &net.OpError{Op: "dial", Err: errors.New("refused")}}
+	b := breaker.New("test", 2, time.Hour)
+	conn := sql.OpenDB(guardedConnector{Connector: connector, guard: guard{breaker: b}})
+	t.Cleanup(func() { conn.Close() })
+
+	for range 2 {
+		_, err := conn.BeginTx(ctx, nil)
+		assert.Error(t, err)
+	}
+	calls := connector.calls
+	_, err := conn.BeginTx(ctx, nil)
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+	assert.Equal(t, calls, connector.calls, "An open breaker should not reach the database")
+}
+
+func TestGuardedConnStatementErrors(t *testing.T) {
+	ctx := context.Background()
+	b := breaker.New("test", 1, time.Hour)
+	g := guard{breaker: b}
+
+	// errors in the statement itself do not count
+	err := g.do(func() error { return &pq.Error{Code: "23505"} })
+	assert.Error(t, err)
+	assert.Equal(t, breaker.Closed, b.State())
+
+	g.slow = time.Millisecond
+	_ = g.do(func() error { time.Sleep(5 * time.Millisecond); return nil })
+	assert.Equal(t, breaker.Open, b.State(), "Slow calls should count as failures")
+
+	conn := sql.OpenDB(guardedConnector{Connector: fakeConnector{&fakeDriver{}}, guard: g})
+	t.Cleanup(func() { conn.Close() })
+	_, err = conn.BeginTx(ctx, nil)
+	assert.ErrorIs(t, err, breaker.ErrOpen)
+}
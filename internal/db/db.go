@@ -1,98 +1,268 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq" // PostgreSQL driver
+	"gonesoft/go-dev-portfolio/internal/backoff"
+	"gonesoft/go-dev-portfolio/internal/breaker"
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/tracing"
+
+	"github.com/XSAM/otelsql"
+	"github.com/lib/pq"
 )
 
 var (
-	db   *sql.DB
-	once sync.Once
+	db         *sql.DB
+	primaryDSN string
+	router     *Router
+	// primaryBreaker guards the primary pool; nil until open has run
+	primaryBreaker *breaker.Breaker
+	openErr        error
+	once           sync.Once
+
+	// reachability of the pool; guarded by mu
+	mu        sync.Mutex
+	reachable bool
+	lastErr   error
+	failures  int
+	nextPing  time.Time
 )
 
-// func getEnv() {
-// 	cwd, _ := os.Getwd()
-// 	root := filepath.Join(cwd, ".env")
-// 	if _, err := os.Stat(root); err == nil {
-// 		_ = godotenv.Load(root)
-// 	} else {
-// 		log.Printf("No .env file found in %s", root)
-// 	}
-// }
-
-// Connect establishes a connection to the database using environment variables.
-func Connect() *sql.DB {
-
-	once.Do(func() {
-
-		// Load environment variables look for .env file in the project root
-		env := filepath.Join(getProjectRoot(), ".env")
-		if err := godotenv.Load(env); err != nil {
-			log.Printf("Error loading .env file: %v", err)
-		}
+// Connect returns the shared connection pool. The first call opens the pool
+// and waits for the database with exponential backoff. If the database is
+// still unreachable the pool is kept and the error returned; later calls
+// probe it again (at most once per backoff delay) so the application
+// recovers on its own once the database comes back. While the circuit
+// breaker around the pool is open it fails fast with ErrUnavailable.
+func Connect() (*sql.DB, error) {
+	once.Do(open)
+	if openErr != nil {
+		return nil, openErr
+	}
+	if primaryBreaker.Check() != nil {
+		return db, ErrUnavailable
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if reachable {
+		return db, nil
+	}
+	if time.Now().Before(nextPing) {
+		return db, lastErr
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.Duration("DB_PING_TIMEOUT", 2*time.Second))
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		markDown(err)
+		return db, lastErr
+	}
+	markUp()
+	return db, nil
+}
+
+// open creates the pool once and waits for the first successful ping.
+func open() {
+	config.Load()
+
+	prefix := ""
+	if strings.HasSuffix(os.Args[0], ".test") {
+		prefix = "TEST_"
+	}
+	primaryDSN = dsn(prefix, os.Getenv(prefix+"DB_HOST"), os.Getenv(prefix+"DB_PORT"))
+	primaryBreaker = breaker.New("database",
+		config.Int("DB_BREAKER_THRESHOLD", 5),
+		config.Duration("DB_BREAKER_OPEN_FOR", 10*time.Second))
+	db, openErr = openPool(primaryDSN, &guard{
+		breaker: primaryBreaker,
+		slow:    config.Duration("DB_BREAKER_SLOW_CALL", 5*time.Second),
+	})
+	if openErr != nil {
+		slog.Error("could not open the database", "error", openErr)
+		return
+	}
 
-		var psqlInfo string
-
-		if strings.HasSuffix(os.Args[0], ".test") {
-			host := os.Getenv("TEST_DB_HOST")
-			port := os.Getenv("TEST_DB_PORT")
-			user := os.Getenv("TEST_DB_USER")
-			password := os.Getenv("TEST_DB_PASSWORD")
-			dbname := os.Getenv("TEST_DB_NAME")
-			sslmode := os.Getenv("TEST_SSL_MODE")
-
-			psqlInfo = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-				host, port, user, password, dbname, sslmode)
-
-		} else {
-			host := os.Getenv("DB_HOST")
-			port := os.Getenv("DB_PORT")
-			user := os.Getenv("DB_USER")
-			password := os.Getenv("DB_PASSWORD")
-			dbname := os.Getenv("DB_NAME")
-			sslmode := os.Getenv("SSL_MODE")
-
-			psqlInfo = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-				host, port, user, password, dbname, sslmode)
+	router = NewRouter(db, config.Duration("DB_REPLICA_MAX_LAG", 5*time.Second))
+	for _, addr := range strings.Split(os.Getenv(prefix+"DB_REPLICAS"), ",") {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			continue
+		}
+		host, port, ok := strings.Cut(addr, ":")
+		if !ok {
+			port = os.Getenv(prefix + "DB_PORT")
 		}
+		replicaDB, err := openPool(dsn(prefix, host, port), nil)
+		if err != nil {
+			slog.Error("could not open read replica", "replica", addr, "error", err)
+			continue
+		}
+		router.AddReplica(addr, replicaDB)
+	}
+	router.Check(context.Background(), config.Duration("DB_PING_TIMEOUT", 2*time.Second))
 
-		var err error
-		for i := 0; i < 10; i++ {
-			db, err = sql.Open("postgres", psqlInfo)
-			if err == nil && db.Ping() == nil {
-				log.Printf("Successfully connected to the database!")
-				return
-			}
-			log.Printf("Waiting for database to be ready... (%d/10)", i+1)
-			time.Sleep(1 * time.Second)
+	attempts := config.Int("DB_CONNECT_ATTEMPTS", 10)
+	err := backoff.Retry(context.Background(), policy(), attempts, func(ctx context.Context, attempt int) error {
+		ctx, cancel := context.WithTimeout(ctx, config.Duration("DB_PING_TIMEOUT", 2*time.Second))
+		defer cancel()
+		err := db.PingContext(ctx)
+		if err != nil {
+			slog.Warn("waiting for database to be ready", "attempt", attempt+1, "max_attempts", attempts, "error", err)
 		}
+		return err
+	})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		slog.Error("database unreachable, continuing and probing again on use", "error", err)
+		markDown(err)
+		return
+	}
+	slog.Info("connected to the database")
+	markUp()
+}
 
-		log.Fatalf("Could not connect to the database: %v", err)
+// dsn returns the connection string for host:port with the credentials read
+// from the environment.
+func dsn(prefix, host, port string) string {
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		host, port, os.Getenv(prefix+"DB_USER"), os.Getenv(prefix+"DB_PASSWORD"),
+		os.Getenv(prefix+"DB_NAME"), os.Getenv(prefix+"SSL_MODE"))
+}
 
-	})
-	return db
+// openPool opens a pool to dsn with the pool settings read from the
+// environment. With a guard every driver call goes through its circuit breaker.
+func openPool(dsn string, g *guard) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	var c driver.Connector = connector
+	if g != nil {
+		c = guardedConnector{Connector: connector, guard: *g}
+	}
+	pool := otelsql.OpenDB(c, tracing.SQLOptions()...)
+	pool.SetMaxOpenConns(config.Int("DB_MAX_OPEN_CONNS", 25))
+	pool.SetMaxIdleConns(config.Int("DB_MAX_IDLE_CONNS", 10))
+	pool.SetConnMaxLifetime(config.Duration("DB_CONN_MAX_LIFETIME", 30*time.Minute))
+	pool.SetConnMaxIdleTime(config.Duration("DB_CONN_MAX_IDLE_TIME", 5*time.Minute))
+	return pool, nil
 }
 
-func getProjectRoot() string {
-	dir, _ := os.Getwd()
-	for {
-		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
-			return dir
-		}
-		parent := filepath.Dir(dir)
-		if parent == dir {
-			break
+// Reader returns the pool read-only queries should use: a healthy read
+// replica when any are configured (DB_REPLICAS), otherwise the primary.
+func Reader(ctx context.Context) DBTX {
+	once.Do(open)
+	if router == nil {
+		return db
+	}
+	return router.Reader(ctx)
+}
+
+// ConnectReader is Connect for read-only requests: it returns the pool Reader
+// picks and only fails when that is the primary and the primary is
+// unavailable, so healthy replicas keep serving reads while it is down.
+func ConnectReader(ctx context.Context) (DBTX, error) {
+	once.Do(open)
+	if openErr != nil {
+		return nil, openErr
+	}
+	reader := Reader(ctx)
+	if reader != DBTX(db) {
+		return reader, nil
+	}
+	if _, err := Connect(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// Replicas returns the state of the configured read replicas.
+func Replicas() []ReplicaStatus {
+	once.Do(open)
+	if router == nil {
+		return nil
+	}
+	return router.Status()
+}
+
+// ReplicaPools returns the pools of the configured read replicas keyed by name.
+func ReplicaPools() map[string]*sql.DB {
+	once.Do(open)
+	if router == nil {
+		return nil
+	}
+	return router.Pools()
+}
+
+// MonitorReplicas re-checks replica health and lag every interval until ctx is done.
+func MonitorReplicas(ctx context.Context, interval time.Duration) {
+	once.Do(open)
+	if router == nil || len(router.replicas) == 0 {
+		return
+	}
+	router.Monitor(ctx, interval, config.Duration("DB_PING_TIMEOUT", 2*time.Second))
+}
+
+func policy() backoff.Policy {
+	return backoff.Policy{
+		Initial:    config.Duration("DB_BACKOFF_INITIAL", 500*time.Millisecond),
+		Max:        config.Duration("DB_BACKOFF_MAX", 10*time.Second),
+		Multiplier: 2,
+		Jitter:     0.5,
+	}
+}
+
+// markDown records a failed ping and schedules the next probe; mu must be held.
+func markDown(err error) {
+	if reachable {
+		slog.Error("database connection lost", "error", err)
+	}
+	reachable = false
+	lastErr = fmt.Errorf("database unreachable: %w", err)
+	nextPing = time.Now().Add(policy().Delay(failures))
+	failures++
+}
+
+// markUp records a successful ping; mu must be held.
+func markUp() {
+	if failures > 0 {
+		slog.Info("database connection recovered", "failed_probes", failures)
+	}
+	reachable = true
+	lastErr = nil
+	failures = 0
+}
+
+// MarkUnreachable makes the next Connect call probe the database again. The
+// health check calls it when a ping fails so that requests stop reporting a
+// stale healthy state.
+func MarkUnreachable(err error) {
+	mu.Lock()
+	defer mu.Unlock()
+	markDown(err)
+	nextPing = time.Time{}
+}
+
+// Close closes the connection pools opened by Connect, if any.
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	if router != nil {
+		if err := router.Close(); err != nil {
+			slog.Error("close read replicas", "error", err)
 		}
-		dir = parent
 	}
-	return ""
+	return db.Close()
 }
@@ -0,0 +1,20 @@
+package db
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// NewListener opens a dedicated LISTEN/NOTIFY connection to the primary,
+// outside the pool. The listener reconnects on its own, waiting between
+// minReconnect and maxReconnect; onEvent, if not nil, is told about
+// connection state changes. After a reconnect it sends a nil notification
+// because anything published in between was lost.
+func NewListener(minReconnect, maxReconnect time.Duration, onEvent pq.EventCallbackType) (*pq.Listener, error) {
+	once.Do(open)
+	if openErr != nil {
+		return nil, openErr
+	}
+	return pq.NewListener(primaryDSN, minReconnect, maxReconnect, onEvent), nil
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// lagQuery reports how far a replica is behind in seconds. A replica that has
+// replayed everything it received counts as current even when the primary has
+// been idle for a while.
+const lagQuery = `
+	SELECT CASE
+		WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()), 0)
+	END`
+
+type replica struct {
+	name    string
+	db      *sql.DB
+	probe   func(ctx context.Context) (time.Duration, error)
+	healthy atomic.Bool
+	lag     atomic.Int64
+}
+
+// ReplicaStatus is the last known state of a read replica.
+type ReplicaStatus struct {
+	Name    string  `json:"name"`
+	Healthy bool    `json:"healthy"`
+	LagMS   float64 `json:"lag_ms"`
+}
+
+// Router sends reads to healthy read replicas in round-robin order and
+// everything else to the primary. Replicas that fail their health check or
+// lag more than maxLag behind are skipped; with none left reads fall back to
+// the primary.
+type Router struct {
+	primary  *sql.DB
+	replicas []*replica
+	maxLag   time.Duration
+	next     atomic.Uint64
+}
+
+// NewRouter returns a Router without replicas.
+func NewRouter(primary *sql.DB, maxLag time.Duration) *Router {
+	return &Router{primary: primary, maxLag: maxLag}
+}
+
+// AddReplica registers a read replica. It receives no reads until a Check
+// has found it healthy.
+func (r *Router) AddReplica(name string, replicaDB *sql.DB) {
+	r.replicas = append(r.replicas, &replica{
+		name: name,
+		db:   replicaDB,
+		probe: func(ctx context.Context) (time.Duration, error) {
+			var seconds float64
+			if err := replicaDB.QueryRowContext(ctx, lagQuery).Scan(&seconds); err != nil {
+				return 0, err
+			}
+			return time.Duration(seconds * float64(time.Second)), nil
+		},
+	})
+}
+
+// Primary returns the primary pool.
+func (r *Router) Primary() *sql.DB {
+	return r.primary
+}
+
+// Pools returns the replica pools keyed by replica name.
+func (r *Router) Pools() map[string]*sql.DB {
+	pools := make(map[string]*sql.DB, len(r.replicas))
+	for _, rep := range r.replicas {
+		pools[rep.name] = rep.db
+	}
+	return pools
+}
+
+// Reader returns the pool a read-only query should use. Requests marked with
+// WithPrimary always read from the primary.
+func (r *Router) Reader(ctx context.Context) DBTX {
+	if len(r.replicas) == 0 || UsePrimary(ctx) {
+		return r.primary
+	}
+	start := r.next.Add(1)
+	for i := range r.replicas {
+		rep := r.replicas[(start+uint64(i))%uint64(len(r.replicas))]
+		if rep.healthy.Load() && time.Duration(rep.lag.Load()) <= r.maxLag {
+			return rep.db
+		}
+	}
+	return r.primary
+}
+
+// Check probes every replica once and records its health and lag.
+func (r *Router) Check(ctx context.Context, timeout time.Duration) {
+	for _, rep := range r.replicas {
+		probeCtx, cancel := context.WithTimeout(ctx, timeout)
+		lag, err := rep.probe(probeCtx)
+		cancel()
+
+		wasHealthy := rep.healthy.Swap(err == nil)
+		rep.lag.Store(int64(lag))
+		switch {
+		case err != nil && wasHealthy:
+			slog.Warn("read replica unhealthy", "replica", rep.name, "error", err)
+		case err == nil && !wasHealthy:
+			slog.Info("read replica healthy", "replica", rep.name, "lag_ms", lag.Milliseconds())
+		case err == nil && lag > r.maxLag:
+			slog.Warn("read replica lagging", "replica", rep.name, "lag_ms", lag.Milliseconds())
+		}
+	}
+}
+
+// Monitor runs Check every interval until ctx is done.
+func (r *Router) Monitor(ctx context.Context, interval, timeout time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.Check(ctx, timeout)
+		}
+	}
+}
+
+// Status returns the last known state of every replica.
+func (r *Router) Status() []ReplicaStatus {
+	out := make([]ReplicaStatus, len(r.replicas))
+	for i, rep := range r.replicas {
+		out[i] = ReplicaStatus{
+			Name:    rep.name,
+			Healthy: rep.healthy.Load(),
+			LagMS:   float64(time.Duration(rep.lag.Load()).Microseconds()) / 1000,
+		}
+	}
+	return out
+}
+
+// Close closes the replica pools.
+func (r *Router) Close() error {
+	var firstErr error
+	for _, rep := range r.replicas {
+		if err := rep.db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type primaryKey struct{}
+
+// WithPrimary marks ctx so that reads go to the primary, for callers that must
+// see their own writes.
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsePrimary reports whether ctx was marked with WithPrimary.
+func UsePrimary(ctx context.Context) bool {
+	v, _ := ctx.Value(primaryKey{}).(bool)
+	return v
+}
+
+const (
+	// ReadYourWritesHeader forces a request to read from the primary.
+	ReadYourWritesHeader = "X-Read-Your-Writes"
+	readYourWritesCookie = "read_your_writes"
+)
+
+// ReadYourWrites routes the reads of a request to the primary when it carries
+// the X-Read-Your-Writes header or the cookie set by a recent write. Every
+// POST, PUT, PATCH and DELETE sets that cookie for window so that the same
+// client keeps reading from the primary until replicas have caught up.
+func ReadYourWrites(window time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary := r.Header.Get(ReadYourWritesHeader) == "true"
+		if _, err := r.Cookie(readYourWritesCookie); err == nil {
+			primary = true
+		}
+		switch r.Method {
+		case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			http.SetCookie(w, &http.Cookie{
+				Name:     readYourWritesCookie,
+				Value:    "1",
+				Path:     "/",
+				MaxAge:   max(int(window.Seconds()), 1),
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		if primary {
+			r = r.WithContext(WithPrimary(r.Context()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/breaker"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeReplica adds a replica whose probe reports lag and err instead of querying.
+func fakeReplica(t *testing.T, r *Router, name string, lag *time.Duration, err *error) *sql.DB {
+	conn, _ := openFake(t)
+	r.replicas = append(r.replicas, &replica{
+		name: name,
+		db:   conn,
+		probe: func(context.Context) (time.Duration, error) {
+			return *lag, *err
+		},
+	})
+	return conn
+}
+
+func TestRouterReader(t *testing.T) {
+	ctx := context.Background()
+	primary, _ := openFake(t)
+	router := NewRouter(primary, time.Second)
+
+	var lagA, lagB time.Duration
+	var errA, errB error
+	a := fakeReplica(t, router, "a", &lagA, &errA)
+	b := fakeReplica(t, router, "b", &lagB, &errB)
+
+	assert.Same(t, primary, router.Reader(ctx), "Unchecked replicas should not receive reads")
+
+	router.Check(ctx, time.Second)
+	seen := map[DBTX]int{}
+	for i := 0; i < 10; i++ {
+		seen[router.Reader(ctx)]++
+	}
+	assert.Equal(t, 5, seen[a], "Reads should alternate between replicas")
+	assert.Equal(t, 5, seen[b], "Reads should alternate between replicas")
+
+	assert.Same(t, primary, router.Reader(WithPrimary(ctx)), "Read-your-writes should use the primary")
+
+	errA = errors.New("connection refused")
+	router.Check(ctx, time.Second)
+	for i := 0; i < 4; i++ {
+		assert.Same(t, b, router.Reader(ctx), "Unhealthy replica should be skipped")
+	}
+
+	lagB = 3 * time.Second
+	router.Check(ctx, time.Second)
+	assert.Same(t, primary, router.Reader(ctx), "Lagging replicas should fall back to the primary")
+
+	status := router.Status()
+	assert.False(t, status[0].Healthy)
+	assert.True(t, status[1].Healthy)
+	assert.Equal(t, float64(3000), status[1].LagMS)
+
+	assert.Equal(t, map[string]*sql.DB{"a": a, "b": b}, router.Pools())
+}
+
+func TestReadYourWrites(t *testing.T) {
+	var usedPrimary bool
+	handler := ReadYourWrites(5*time.Second, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usedPrimary = UsePrimary(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.False(t, usedPrimary)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users", nil))
+	cookies := rec.Result().Cookies()
+	assert.Len(t, cookies, 1, "Writes should set the read-your-writes cookie")
+	assert.Equal(t, 5, cookies[0].MaxAge)
+
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.AddCookie(cookies[0])
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, usedPrimary, "Reads after a write should go to the primary")
+
+	req = httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set(ReadYourWritesHeader, "true")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	assert.True(t, usedPrimary, "The header should force the primary")
+}
+
+func TestConnectReader(t *testing.T) {
+	once.Do(func() {})
+	savedDB, savedRouter, savedBreaker := db, router, primaryBreaker
+	t.Cleanup(func() {
+		db, router, primaryBreaker = savedDB, savedRouter, savedBreaker
+		mu.Lock()
+		reachable, lastErr, nextPing = false, nil, time.Time{}
+		mu.Unlock()
+	})
+
+	ctx := context.Background()
+	primary, _ := openFake(t)
+	db, router, primaryBreaker = primary, NewRouter(primary, time.Second), breaker.New("test", 1, time.Minute)
+	mu.Lock()
+	reachable, lastErr, nextPing = false, errors.New("primary down"), time.Now().Add(time.Hour)
+	mu.Unlock()
+
+	_, err := ConnectReader(ctx)
+	assert.Error(t, err, "Without replicas reads need the primary")
+
+	var lag time.Duration
+	var probeErr error
+	replica := fakeReplica(t, router, "a", &lag, &probeErr)
+	router.Check(ctx, time.Second)
+	reader, err := ConnectReader(ctx)
+	assert.NoError(t, err, "A healthy replica should serve reads while the primary is down")
+	assert.Same(t, replica, reader)
+
+	_, err = ConnectReader(WithPrimary(ctx))
+	assert.Error(t, err, "Read-your-writes requests still need the primary")
+}
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SchemaVersion is the schema_migrations version this build expects.
+// Bump it together with every schema change in init.sql.
+const SchemaVersion = 12
+
+// CheckSchema returns an error unless the database schema is at SchemaVersion or newer.
+func CheckSchema(ctx context.Context, database *sql.DB) error {
+	var version int
+	err := database.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("read schema version: %w", err)
+	}
+	if version < SchemaVersion {
+		return fmt.Errorf("schema at version %d, want %d", version, SchemaVersion)
+	}
+	return nil
+}
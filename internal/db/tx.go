@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/backoff"
+	"gonesoft/go-dev-portfolio/internal/logging"
+
+	"github.com/lib/pq"
+)
+
+// DBTX is the subset of *sql.DB and *sql.Tx used by repositories, so the same
+// statements run inside or outside a transaction.
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+type beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+type txConfig struct {
+	opts     sql.TxOptions
+	attempts int
+	policy   backoff.Policy
+}
+
+// TxOption configures WithTx.
+type TxOption func(*txConfig)
+
+// Isolation sets the isolation level of the transaction.
+func Isolation(level sql.IsolationLevel) TxOption {
+	return func(c *txConfig) { c.opts.Isolation = level }
+}
+
+// ReadOnly starts a read-only transaction.
+func ReadOnly() TxOption {
+	return func(c *txConfig) { c.opts.ReadOnly = true }
+}
+
+// Attempts sets how many times a transaction is tried when it fails with a
+// serialization failure or deadlock. 1 disables retries.
+func Attempts(n int) TxOption {
+	return func(c *txConfig) { c.attempts = n }
+}
+
+// WithTx runs fn inside a transaction on q and commits when fn returns nil.
+// The transaction is rolled back when fn returns an error or panics; the
+// error (or panic) is passed on. Serialization failures (40001) and deadlocks
+// (40P01) run fn again in a fresh transaction, so fn must not have side
+// effects outside the database.
+//
+// If q is already a *sql.Tx, fn joins it: options are ignored and commit,
+// rollback and retries are left to the outer WithTx.
+func WithTx(ctx context.Context, q DBTX, fn func(tx DBTX) error, opts ...TxOption) error {
+	if tx, ok := q.(*sql.Tx); ok {
+		return fn(tx)
+	}
+	b, ok := q.(beginner)
+	if !ok {
+		return fmt.Errorf("db: %T cannot begin transactions", q)
+	}
+
+	cfg := txConfig{
+		attempts: 3,
+		policy:   backoff.Policy{Initial: 20 * time.Millisecond, Max: 500 * time.Millisecond, Multiplier: 2, Jitter: 0.5},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	attempts := max(cfg.attempts, 1)
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, b, &cfg.opts, fn)
+		if err == nil || !IsRetryable(err) || attempt+1 >= attempts {
+			return err
+		}
+		logging.FromContext(ctx).Warn("retrying transaction", "attempt", attempt+1, "error", err)
+		if sleepErr := backoff.Sleep(ctx, cfg.policy.Delay(attempt)); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+func runTx(ctx context.Context, b beginner, opts *sql.TxOptions, fn func(tx DBTX) error) (err error) {
+	tx, err := b.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsRetryable reports whether err is a serialization failure or deadlock that
+// succeeds when the transaction is run again.
+func IsRetryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	switch pqErr.Code {
+	case "40001", "40P01":
+		return true
+	}
+	return false
+}
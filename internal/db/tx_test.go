@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDriver counts transaction outcomes without a database.
+type fakeDriver struct {
+	mu                         sync.Mutex
+	begins, commits, rollbacks int
+	lastOpts                   driver.TxOptions
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{d: d}, nil }
+
+type fakeConn struct{ d *fakeDriver }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *fakeConn) Close() error                        { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.d.mu.Lock()
+	defer c.d.mu.Unlock()
+	c.d.begins++
+	c.d.lastOpts = opts
+	return &fakeTx{d: c.d}, nil
+}
+
+type fakeTx struct{ d *fakeDriver }
+
+func (t *fakeTx) Commit() error {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+	t.d.commits++
+	return nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.d.mu.Lock()
+	defer t.d.mu.Unlock()
+	t.d.rollbacks++
+	return nil
+}
+
+type fakeConnector struct{ d *fakeDriver }
+
+func (c fakeConnector) Connect(context.Context) (driver.Conn, error) { return c.d.Open("") }
+func (c fakeConnector) Driver() driver.Driver                        { return c.d }
+
+func openFake(t *testing.T) (*sql.DB, *fakeDriver) {
+	d := &fakeDriver{}
+	conn := sql.OpenDB(fakeConnector{d})
+	t.Cleanup(func() { conn.Close() })
+	return conn, d
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("commits on success", func(t *testing.T) {
+		conn, d := openFake(t)
+		err := WithTx(ctx, conn, func(DBTX) error { return nil }, Isolation(sql.LevelSerializable))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, d.commits)
+		assert.Equal(t, 0, d.rollbacks)
+		assert.Equal(t, driver.IsolationLevel(sql.LevelSerializable), d.lastOpts.Isolation)
+	})
+
+	t.Run("rolls back on error", func(t *testing.T) {
+		conn, d := openFake(t)
+		boom := errors.New("boom")
+		err := WithTx(ctx, conn, func(DBTX) error { return boom })
+		assert.ErrorIs(t, err, boom)
+		assert.Equal(t, 0, d.commits)
+		assert.Equal(t, 1, d.rollbacks)
+	})
+
+	t.Run("rolls back on panic", func(t *testing.T) {
+		conn, d := openFake(t)
+		assert.PanicsWithValue(t, "boom", func() {
+			_ = WithTx(ctx, conn, func(DBTX) error { panic("boom") })
+		})
+		assert.Equal(t, 1, d.rollbacks)
+	})
+
+	t.Run("retries serialization failures", func(t *testing.T) {
+		conn, d := openFake(t)
+		calls := 0
+		err := WithTx(ctx, conn, func(DBTX) error {
+			calls++
+			if calls < 3 {
+				return &pq.Error{Code: "40001"}
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, calls)
+		assert.Equal(t, 3, d.begins)
+		assert.Equal(t, 2, d.rollbacks)
+		assert.Equal(t, 1, d.commits)
+	})
+
+	t.Run("gives up after the last attempt", func(t *testing.T) {
+		conn, _ := openFake(t)
+		calls := 0
+		err := WithTx(ctx, conn, func(DBTX) error {
+			calls++
+			return &pq.Error{Code: "40P01"}
+		}, Attempts(2))
+		assert.True(t, IsRetryable(err))
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("nested calls join the outer transaction", func(t *testing.T) {
+		conn, d := openFake(t)
+		err := WithTx(ctx, conn, func(tx DBTX) error {
+			return WithTx(ctx, tx, func(inner DBTX) error {
+				assert.Same(t, tx, inner)
+				return nil
+			})
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, d.begins)
+		assert.Equal(t, 1, d.commits)
+	})
+}
@@ -0,0 +1,95 @@
+// Package emailaddr reduces email addresses to the canonical form the users
+// table compares them by, so two spellings of one mailbox collide:
+//
+//	" Ada.Lovelace+news@GMAIL.com " -> "adalovelace@gmail.com" (with provider rules)
+//	"José@Bücher.example"          -> "josé@xn--bcher-kva.example"
+//
+// The address as the user typed it is kept for display; only the canonical
+// form is used for uniqueness, lookups and search.
+package emailaddr
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+var ErrInvalid = errors.New("not an email address")
+
+// Options selects the optional rules. Changing them changes canonical forms,
+// so stored values must be recomputed afterwards.
+type Options struct {
+	// ProviderRules applies the aliasing rules of the providers in providers,
+	// e.g. Gmail ignoring dots and +tags in the local part.
+	ProviderRules bool
+}
+
+type provider struct {
+	domain   string // domain the provider's aliases map to
+	dropDots bool   // dots in the local part are ignored
+	dropTag  bool   // everything from the first + is a tag
+}
+
+var providers = map[string]provider{
+	"gmail.com":      {domain: "gmail.com", dropDots: true, dropTag: true},
+	"googlemail.com": {domain: "gmail.com", dropDots: true, dropTag: true},
+}
+
+// Canonical returns the canonical form of addr: surrounding whitespace
+// removed, the local part in NFC and lower case, and the domain in lower-case
+// ASCII, with internationalized domains converted to punycode. Syntax beyond
+// local@domain is checked by validate.Email, not here.
+func Canonical(addr string, opts Options) (string, error) {
+	addr = strings.TrimSpace(addr)
+	at := strings.LastIndexByte(addr, '@')
+	if at <= 0 || at == len(addr)-1 {
+		return "", ErrInvalid
+	}
+	local := strings.ToLower(norm.NFC.String(addr[:at]))
+	domain, err := Domain(addr[at+1:])
+	if err != nil {
+		return "", err
+	}
+
+	if p, ok := providers[domain]; ok && opts.ProviderRules && !strings.HasPrefix(local, `"`) {
+		if i := strings.IndexByte(local, '+'); p.dropTag && i >= 0 {
+			local = local[:i]
+		}
+		if p.dropDots {
+			local = strings.ReplaceAll(local, ".", "")
+		}
+		if local == "" {
+			return "", ErrInvalid
+		}
+		domain = p.domain
+	}
+	return local + "@" + domain, nil
+}
+
+// Domain returns the lower-case ASCII form of an email domain. Domain
+// literals like [192.0.2.1] and ASCII names are only lower-cased, so names
+// that DNS allows but IDNA does not (e.g. with underscores) still work.
+func Domain(domain string) (string, error) {
+	if domain == "" || strings.HasSuffix(domain, ".") {
+		return "", ErrInvalid
+	}
+	if strings.HasPrefix(domain, "[") || isASCII(domain) {
+		return strings.ToLower(domain), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", ErrInvalid
+	}
+	return ascii, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
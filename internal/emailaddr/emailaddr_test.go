@@ -0,0 +1,43 @@
+package emailaddr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		in, want, withProviders string
+	}{
+		{"ada@example.com", "ada@example.com", "ada@example.com"},
+		{"  Ada@Example.COM\n", "ada@example.com", "ada@example.com"},
+		{"Ada.Lovelace+news@GMAIL.com", "ada.lovelace+news@gmail.com", "adalovelace@gmail.com"},
+		{"a.d.a@googlemail.com", "a.d.a@googlemail.com", "ada@gmail.com"},
+		{"ada+news@example.com", "ada+news@example.com", "ada+news@example.com"},
+		{`"Ada.L"@gmail.com`, `"ada.l"@gmail.com`, `"ada.l"@gmail.com`},
+		{"José@Bücher.example", "josé@xn--bcher-kva.example", "josé@xn--bcher-kva.example"},
+		{"José@example.com", "josé@example.com", "josé@example.com"},
+		{"ada@[192.0.2.1]", "ada@[192.0.2.1]", "ada@[192.0.2.1]"},
+		{"ada@my_host.example", "ada@my_host.example", "ada@my_host.example"},
+	}
+	for _, tt := range tests {
+		got, err := Canonical(tt.in, Options{})
+		if assert.NoError(t, err, tt.in) {
+			assert.Equal(t, tt.want, got, tt.in)
+		}
+		got, err = Canonical(tt.in, Options{ProviderRules: true})
+		if assert.NoError(t, err, tt.in) {
+			assert.Equal(t, tt.withProviders, got, tt.in)
+		}
+	}
+}
+
+func TestCanonicalRejectsNonAddresses(t *testing.T) {
+	for _, in := range []string{"", "ada", "@example.com", "ada@", "ada@example.com.", "ada@-bücher.example", "ada@bü cher.example"} {
+		_, err := Canonical(in, Options{})
+		assert.ErrorIs(t, err, ErrInvalid, in)
+	}
+	_, err := Canonical("+tag@gmail.com", Options{ProviderRules: true})
+	assert.ErrorIs(t, err, ErrInvalid, "Nothing is left of the mailbox")
+}
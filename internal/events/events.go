@@ -0,0 +1,73 @@
+// Package events defines the domain events of the user lifecycle and
+// delivers them through a transactional outbox: events are written in the
+// same transaction as the change and published afterwards by a Relay.
+package events
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	TypeUserCreated  = "user.created"
+	TypeUserUpdated  = "user.updated"
+	TypeUserDeleted  = "user.deleted"
+	TypeUserRestored = "user.restored"
+)
+
+// Event is a domain event about one user.
+type Event interface {
+	EventType() string
+	UserID() int
+}
+
+// UserCreated is published when a user is created.
+type UserCreated struct {
+	ID      int    `json:"user_id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Version int    `json:"version"`
+}
+
+// UserUpdated is published when a user's fields change; Changed lists them.
+type UserUpdated struct {
+	ID      int      `json:"user_id"`
+	Name    string   `json:"name"`
+	Email   string   `json:"email"`
+	Version int      `json:"version"`
+	Changed []string `json:"changed"`
+}
+
+// UserDeleted is published when a user is soft-deleted.
+type UserDeleted struct {
+	ID int `json:"user_id"`
+}
+
+// UserRestored is published when a soft-deleted user is restored.
+type UserRestored struct {
+	ID      int    `json:"user_id"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Version int    `json:"version"`
+}
+
+func (e UserCreated) EventType() string  { return TypeUserCreated }
+func (e UserUpdated) EventType() string  { return TypeUserUpdated }
+func (e UserDeleted) EventType() string  { return TypeUserDeleted }
+func (e UserRestored) EventType() string { return TypeUserRestored }
+
+func (e UserCreated) UserID() int  { return e.ID }
+func (e UserUpdated) UserID() int  { return e.ID }
+func (e UserDeleted) UserID() int  { return e.ID }
+func (e UserRestored) UserID() int { return e.ID }
+
+// Envelope is an event as stored in the outbox and handed to sinks. ID
+// increases with every event and identifies it for consumers that need to
+// drop duplicates.
+type Envelope struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	UserID     int             `json:"user_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+}
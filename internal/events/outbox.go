@@ -0,0 +1,53 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+)
+
+// enqueueChunkSize keeps multi-row INSERTs well below Postgres' 65535 parameter limit.
+const enqueueChunkSize = 1000
+
+// Enqueue writes events to the outbox. Pass the transaction of the change so
+// that the events are published if and only if it commits.
+func Enqueue(ctx context.Context, q db.DBTX, events ...Event) error {
+	for start := 0; start < len(events); start += enqueueChunkSize {
+		chunk := events[start:min(start+enqueueChunkSize, len(events))]
+		values := make([]string, len(chunk))
+		args := make([]interface{}, 0, len(chunk)*3)
+		for i, e := range chunk {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			n := len(args)
+			values[i] = fmt.Sprintf("($%d, $%d, $%d)", n+1, n+2, n+3)
+			args = append(args, e.EventType(), e.UserID(), data)
+		}
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO outbox (event_type, aggregate_id, payload)
+			VALUES `+strings.Join(values, ", "), args...)
+		if err != nil {
+			return fmt.Errorf("enqueue events: %w", err)
+		}
+	}
+	return nil
+}
+
+// PurgePublished deletes events published before olderThan and returns how
+// many were removed.
+func PurgePublished(ctx context.Context, q db.DBTX, olderThan time.Duration) (int64, error) {
+	result, err := q.ExecContext(ctx, `
+		DELETE FROM outbox
+		WHERE published_at < NOW() - MAKE_INTERVAL(secs => $1)
+	`, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,158 @@
+package events
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/backoff"
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+)
+
+// Sink receives published events. Delivery is at least once, so a sink may
+// see the same envelope again after a failure or a crash; Envelope.ID
+// identifies duplicates.
+type Sink interface {
+	Publish(ctx context.Context, e Envelope) error
+}
+
+// SinkFunc adapts a function to the Sink interface.
+type SinkFunc func(ctx context.Context, e Envelope) error
+
+func (f SinkFunc) Publish(ctx context.Context, e Envelope) error { return f(ctx, e) }
+
+// LogSink writes every event to a logger. The payload holds the user's name
+// and email, so only the event id, type and user are logged.
+type LogSink struct {
+	Logger *slog.Logger
+}
+
+func (s LogSink) Publish(ctx context.Context, e Envelope) error {
+	s.Logger.InfoContext(ctx, "domain event", "event_id", e.ID, "type", e.Type, "user_id", e.UserID)
+	return nil
+}
+
+// claimQuery picks due events that are the oldest unpublished event of their
+// user. Later events of the same user wait until the earlier one is out,
+// which keeps per-user ordering even with several relays running.
+const claimQuery = `
+	SELECT id, event_type, aggregate_id, occurred_at, payload, attempts
+	FROM outbox o
+	WHERE published_at IS NULL
+	  AND next_attempt_at <= NOW()
+	  AND NOT EXISTS (
+		SELECT 1 FROM outbox earlier
+		WHERE earlier.aggregate_id = o.aggregate_id
+		  AND earlier.published_at IS NULL
+		  AND earlier.id < o.id
+	  )
+	ORDER BY id
+	LIMIT $1
+	FOR UPDATE SKIP LOCKED`
+
+// Relay publishes outbox events to its sinks. An event counts as published
+// once every sink accepted it; otherwise it is retried with backoff.
+type Relay struct {
+	db        *sql.DB
+	sinks     []Sink
+	batchSize int
+	backoff   backoff.Policy
+}
+
+// NewRelay returns a Relay reading the outbox of database.
+func NewRelay(database *sql.DB, batchSize int, sinks ...Sink) *Relay {
+	return &Relay{
+		db:        database,
+		sinks:     sinks,
+		batchSize: max(batchSize, 1),
+		backoff:   backoff.Policy{Initial: time.Second, Max: 10 * time.Minute, Multiplier: 2, Jitter: 0.2},
+	}
+}
+
+// Run publishes due events every interval until ctx is done. Full batches are
+// followed immediately by the next one.
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	for {
+		n, err := r.Process(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("outbox relay", "error", err)
+		}
+		if n == r.batchSize && err == nil {
+			continue
+		}
+		if backoff.Sleep(ctx, interval) != nil {
+			return
+		}
+	}
+}
+
+// Process handles one batch of due events and returns how many were published.
+func (r *Relay) Process(ctx context.Context) (int, error) {
+	published := 0
+	err := db.WithTx(ctx, r.db, func(tx db.DBTX) error {
+		rows, err := tx.QueryContext(ctx, claimQuery, r.batchSize)
+		if err != nil {
+			return err
+		}
+		var batch []Envelope
+		var attempts []int
+		for rows.Next() {
+			var e Envelope
+			var n int
+			if err := rows.Scan(&e.ID, &e.Type, &e.UserID, &e.OccurredAt, &e.Data, &n); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, e)
+			attempts = append(attempts, n)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return err
+		}
+
+		for i, e := range batch {
+			if pubErr := r.publish(ctx, e); pubErr != nil {
+				delay := r.backoff.Delay(attempts[i])
+				slog.Warn("publish event failed", "event_id", e.ID, "type", e.Type, "attempt", attempts[i]+1,
+					"retry_in", delay, "error", pubErr)
+				metrics.EventsPublished.WithLabelValues(e.Type, "error").Inc()
+				_, err = tx.ExecContext(ctx, `
+					UPDATE outbox SET attempts = attempts + 1, last_error = $2,
+						next_attempt_at = NOW() + MAKE_INTERVAL(secs => $3)
+					WHERE id = $1
+				`, e.ID, pubErr.Error(), delay.Seconds())
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			metrics.EventsPublished.WithLabelValues(e.Type, "ok").Inc()
+			_, err = tx.ExecContext(ctx, `
+				UPDATE outbox SET attempts = attempts + 1, last_error = NULL, published_at = NOW()
+				WHERE id = $1
+			`, e.ID)
+			if err != nil {
+				return err
+			}
+			published++
+		}
+		return nil
+	}, db.Attempts(1))
+	return published, err
+}
+
+func (r *Relay) publish(ctx context.Context, e Envelope) error {
+	var errs []error
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, e); err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", sink, err))
+		}
+	}
+	return errors.Join(errs...)
+}
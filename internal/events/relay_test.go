@@ -0,0 +1,78 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelay(t *testing.T) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("DELETE FROM outbox")
+	ctx := context.Background()
+
+	err := db.WithTx(ctx, conn, func(tx db.DBTX) error {
+		return Enqueue(ctx, tx,
+			UserCreated{ID: 1, Name: "Ann", Email: "ann@example.com", Version: 1},
+			UserUpdated{ID: 1, Name: "Ann", Email: "ann@new.com", Version: 2, Changed: []string{"email"}},
+			UserCreated{ID: 2, Name: "Bo", Email: "bo@example.com", Version: 1},
+		)
+	})
+	assert.NoError(t, err)
+
+	var mu sync.Mutex
+	var got []string
+	failing := true
+	relay := NewRelay(conn, 10, SinkFunc(func(ctx context.Context, e Envelope) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing && e.UserID == 1 {
+			return errors.New("sink down")
+		}
+		got = append(got, e.Type)
+		return nil
+	}))
+
+	n, err := relay.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n, "Only user 2 should get through while the sink rejects user 1")
+
+	var attempts int
+	var lastError string
+	_ = conn.QueryRow(`SELECT attempts, last_error FROM outbox
+		WHERE aggregate_id = 1 AND event_type = $1`, TypeUserCreated).Scan(&attempts, &lastError)
+	assert.Equal(t, 1, attempts)
+	assert.Contains(t, lastError, "sink down")
+
+	// make the failed event due again instead of waiting for the backoff
+	failing = false
+	_, _ = conn.Exec(`UPDATE outbox SET next_attempt_at = NOW() WHERE published_at IS NULL`)
+	for i := 0; i < 3; i++ {
+		_, err = relay.Process(ctx)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{TypeUserCreated, TypeUserCreated, TypeUserUpdated}, got,
+		"User 1 events should be published in order after the retry")
+
+	var pending int
+	_ = conn.QueryRow(`SELECT COUNT(*) FROM outbox WHERE published_at IS NULL`).Scan(&pending)
+	assert.Equal(t, 0, pending)
+}
+
+func TestLogSinkOmitsPayload(t *testing.T) {
+	var buf bytes.Buffer
+	sink := LogSink{Logger: slog.New(slog.NewJSONHandler(&buf, nil))}
+	err := sink.Publish(context.Background(), Envelope{ID: 3, Type: "user.created", UserID: 1,
+		Data: []byte(`{"user_id":1,"name":"Ann","email":"ann@example.com"}`)})
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `"user_id":1`)
+	assert.NotContains(t, buf.String(), "ann@example.com", "The payload holds PII and should not be logged")
+	assert.NotContains(t, buf.String(), "Ann")
+}
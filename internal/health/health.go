@@ -0,0 +1,188 @@
+// Package health serves the liveness (/healthz) and readiness (/readyz)
+// endpoints used by load balancers and orchestrators.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+)
+
+// Check reports the health of one dependency; nil means healthy.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name     string
+	critical bool
+	check    Check
+}
+
+// CheckResult is the outcome of one check in the /readyz response.
+type CheckResult struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the /readyz response body.
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+const (
+	StatusUp       = "up"
+	StatusDown     = "down"
+	StatusReady    = "ready"
+	StatusNotReady = "not_ready"
+	StatusDegraded = "degraded"
+	StatusDraining = "draining"
+)
+
+// Checker runs the registered readiness checks.
+type Checker struct {
+	timeout  time.Duration
+	draining atomic.Bool
+
+	mu     sync.RWMutex
+	checks []namedCheck
+}
+
+// NewChecker returns a Checker that gives each check at most timeout to answer.
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Register adds a readiness check. A failing critical check makes the
+// instance not ready; other failures only mark it degraded.
+func (c *Checker) Register(name string, critical bool, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, namedCheck{name: name, critical: critical, check: check})
+}
+
+// SetDraining flips readiness off so that load balancers stop sending traffic
+// while in-flight requests finish.
+func (c *Checker) SetDraining() {
+	c.draining.Store(true)
+}
+
+// Draining reports whether SetDraining was called.
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Live handles GET /healthz. It only reports that the process is serving.
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	httphelper.JSON(w, http.StatusOK, map[string]string{"status": StatusUp})
+}
+
+// Ready handles GET /readyz with a per-check report.
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+	status := http.StatusOK
+	if report.Status == StatusNotReady || report.Status == StatusDraining {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	httphelper.JSON(w, status, report)
+}
+
+// Run executes every check concurrently and summarizes the results.
+func (c *Checker) Run(ctx context.Context) Report {
+	c.mu.RLock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.RUnlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, nc := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.runOne(ctx, nc)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusReady, Checks: map[string]CheckResult{}}
+	for i, nc := range checks {
+		report.Checks[nc.name] = results[i]
+		if results[i].Status == StatusUp {
+			continue
+		}
+		if nc.critical {
+			report.Status = StatusNotReady
+		} else if report.Status == StatusReady {
+			report.Status = StatusDegraded
+		}
+	}
+	if c.Draining() {
+		report.Status = StatusDraining
+	}
+	return report
+}
+
+func (c *Checker) runOne(ctx context.Context, nc namedCheck) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				errc <- fmt.Errorf("check panicked: %v", p)
+			}
+		}()
+		errc <- nc.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := CheckResult{
+		Status:    StatusUp,
+		Critical:  nc.critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Error = "timed out after " + c.timeout.String()
+		}
+	}
+	return result
+}
+
+// HTTPCheck reports a downstream HTTP dependency healthy when url answers
+// a GET with a status below 500.
+func HTTPCheck(url string) Check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ready(t *testing.T, c *Checker) (int, Report) {
+	rec := httptest.NewRecorder()
+	c.Ready(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var report Report
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestReady(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("boom") }
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	t.Run("all checks up", func(t *testing.T) {
+		c := NewChecker(time.Second)
+		c.Register("database", true, ok)
+		code, report := ready(t, c)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, StatusReady, report.Status)
+		assert.Equal(t, StatusUp, report.Checks["database"].Status)
+	})
+
+	t.Run("non-critical failure degrades", func(t *testing.T) {
+		c := NewChecker(time.Second)
+		c.Register("database", true, ok)
+		c.Register("collector", false, fail)
+		code, report := ready(t, c)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, StatusDegraded, report.Status)
+		assert.Equal(t, "boom", report.Checks["collector"].Error)
+	})
+
+	t.Run("critical failure is not ready", func(t *testing.T) {
+		c := NewChecker(time.Second)
+		c.Register("database", true, fail)
+		code, report := ready(t, c)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, StatusNotReady, report.Status)
+	})
+
+	t.Run("slow check times out", func(t *testing.T) {
+		c := NewChecker(20 * time.Millisecond)
+		c.Register("database", true, slow)
+		code, report := ready(t, c)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Contains(t, report.Checks["database"].Error, "timed out")
+	})
+
+	t.Run("draining is not ready", func(t *testing.T) {
+		c := NewChecker(time.Second)
+		c.Register("database", true, ok)
+		c.SetDraining()
+		code, report := ready(t, c)
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+		assert.Equal(t, StatusDraining, report.Status)
+	})
+}
+
+func TestHTTPCheck(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	assert.NoError(t, HTTPCheck(up.URL)(context.Background()))
+	assert.Error(t, HTTPCheck(down.URL)(context.Background()))
+}
@@ -0,0 +1,58 @@
+package httphelper
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/validate"
+)
+
+var ErrTrailingData = errors.New("body must contain a single JSON value")
+
+// MaxBodyBytes is the largest JSON request body the API reads (HTTP_MAX_BODY_BYTES).
+func MaxBodyBytes() int64 {
+	return int64(config.Int("HTTP_MAX_BODY_BYTES", 1<<20))
+}
+
+// DecodeJSON reads a single JSON value from the body of r into dst. Bodies
+// over MaxBodyBytes and fields dst does not declare are rejected.
+func DecodeJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxBodyBytes()))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return ErrTrailingData
+	}
+	return nil
+}
+
+// DecodeError writes the response for an error from DecodeJSON or from
+// reading a body limited by MaxBodyBytes.
+func DecodeError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		Error(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+		return
+	}
+	Error(w, http.StatusBadRequest, "Invalid request payload: "+strings.TrimPrefix(err.Error(), "json: "))
+}
+
+// ValidationError writes err with the failed fields when it is a validate.Errors.
+func ValidationError(w http.ResponseWriter, status int, err error) {
+	var fields validate.Errors
+	if !errors.As(err, &fields) {
+		Error(w, status, err.Error())
+		return
+	}
+	JSON(w, status, map[string]interface{}{"error": "Validation failed", "fields": fields})
+}
@@ -0,0 +1,88 @@
+package httphelper
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrPreconditionRequired = errors.New("If-Match header is required")
+	ErrPreconditionFailed   = errors.New("If-Match does not match the current version")
+)
+
+// ETag formats a row version as a strong entity tag, e.g. "3".
+func ETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// IfMatchVersions returns the versions listed in the If-Match header
+// (RFC 9110 section 13.1.1); the write may proceed if the stored version is
+// any of them. "*" matches any existing version and is returned as nil.
+func IfMatchVersions(r *http.Request) ([]int, error) {
+	header := strings.TrimSpace(strings.Join(r.Header.Values("If-Match"), ","))
+	if header == "" {
+		return nil, ErrPreconditionRequired
+	}
+
+	var versions []int
+	for rest := header; rest != ""; {
+		rest = strings.TrimLeft(rest, " \t,")
+		if rest == "" {
+			break
+		}
+		if rest[0] == '*' {
+			return nil, nil
+		}
+		weak := strings.HasPrefix(rest, "W/")
+		rest = strings.TrimPrefix(rest, "W/")
+		// an entity-tag is an opaque quoted string and may itself contain commas
+		if !strings.HasPrefix(rest, `"`) {
+			return nil, ErrPreconditionFailed
+		}
+		end := strings.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return nil, ErrPreconditionFailed
+		}
+		opaque := rest[1 : end+1]
+		rest = rest[end+2:]
+
+		// weak tags never match under the strong comparison If-Match requires
+		if weak {
+			continue
+		}
+		if version, err := strconv.Atoi(opaque); err == nil && version > 0 {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, ErrPreconditionFailed
+	}
+	return versions, nil
+}
+
+// NoneMatch reports whether the If-None-Match header of r matches etag,
+// using the weak comparison required for conditional GETs.
+func NoneMatch(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// PreconditionError writes the response for an If-Match error.
+func PreconditionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrPreconditionRequired) {
+		Error(w, http.StatusPreconditionRequired, err.Error())
+		return
+	}
+	Error(w, http.StatusPreconditionFailed, err.Error())
+}
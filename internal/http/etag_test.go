@@ -0,0 +1,49 @@
+package httphelper
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIfMatchVersions(t *testing.T) {
+	cases := []struct {
+		header string
+		want   []int
+		err    error
+	}{
+		{``, nil, ErrPreconditionRequired},
+		{`*`, nil, nil},
+		{`"3"`, []int{3}, nil},
+		{` "3" `, []int{3}, nil},
+		{`"3", "5"`, []int{3, 5}, nil},
+		{`"3","5"`, []int{3, 5}, nil},
+		{`W/"3", "5"`, []int{5}, nil},
+		{`"abc", "5"`, []int{5}, nil},
+		{`W/"3"`, nil, ErrPreconditionFailed},
+		{`"a,b"`, nil, ErrPreconditionFailed},
+		{`3`, nil, ErrPreconditionFailed},
+		{`"3", 5`, nil, ErrPreconditionFailed},
+		{`"3`, nil, ErrPreconditionFailed},
+		{`"0"`, nil, ErrPreconditionFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.header, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/users/1", nil)
+			if tc.header != "" {
+				r.Header.Set("If-Match", tc.header)
+			}
+			got, err := IfMatchVersions(r)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	r := httptest.NewRequest("PUT", "/users/1", nil)
+	r.Header.Add("If-Match", `"3"`)
+	r.Header.Add("If-Match", `"4"`)
+	got, err := IfMatchVersions(r)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 4}, got, "repeated header lines form one list")
+}
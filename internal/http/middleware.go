@@ -0,0 +1,233 @@
+package httphelper
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/logging"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+type clientIPKey struct{}
+
+// RequestID makes sure every request has an ID: the incoming X-Request-ID
+// header, or a new random one. The ID is echoed in the response and stored in
+// the request context together with the client IP.
+func RequestID(next http.Handler) http.Handler {
+	trustProxy := config.Bool("HTTP_TRUST_PROXY", false)
+	proxies := trustedProxies(config.String("HTTP_TRUSTED_PROXIES", ""))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = context.WithValue(ctx, clientIPKey{}, ClientIP(r, trustProxy, proxies))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the address of the caller. With trustProxy it comes from
+// X-Forwarded-For, read from the right: each proxy appends the address it saw,
+// so the rightmost entry that is not one of the proxies is the client. Entries
+// further left were sent by the client itself and cannot be trusted.
+func ClientIP(r *http.Request, trustProxy bool, proxies []netip.Prefix) string {
+	if trustProxy {
+		var entries []string
+		for _, fwd := range r.Header.Values("X-Forwarded-For") {
+			entries = append(entries, strings.Split(fwd, ",")...)
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			entry := strings.TrimSpace(entries[i])
+			addr, err := netip.ParseAddr(entry)
+			if err != nil {
+				// garbage is not a proxy we know, so nothing left of it is trustworthy
+				return entry
+			}
+			if i == 0 || !isProxy(addr, proxies) {
+				return addr.Unmap().String()
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isProxy(addr netip.Addr, proxies []netip.Prefix) bool {
+	for _, p := range proxies {
+		if p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies parses HTTP_TRUSTED_PROXIES, a comma-separated list of CIDRs
+// or addresses of the proxies between the load balancer and the API.
+func trustedProxies(list string) []netip.Prefix {
+	var out []netip.Prefix
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(entry)
+			if addrErr != nil {
+				slog.Error("ignoring invalid HTTP_TRUSTED_PROXIES entry", "entry", entry, "error", err)
+				continue
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out
+}
+
+// ClientIPFromContext returns the client IP stored by RequestID, or "".
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+// RequestIDFromContext returns the ID set by RequestID, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// AccessLog logs one line per request with method, route pattern, status,
+// latency and response size. Handlers find a logger carrying the request ID
+// in the request context (see logging.FromContext).
+func AccessLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		reqLogger := logger.With("request_id", RequestIDFromContext(r.Context()))
+		ctx := logging.WithContext(r.Context(), reqLogger)
+		r = r.WithContext(context.WithValue(ctx, routeKey{}, new(string)))
+
+		sw := WrapWriter(w)
+		next.ServeHTTP(sw, r)
+
+		route := Route(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		level := slog.LevelInfo
+		if sw.Status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		reqLogger.LogAttrs(r.Context(), level, "http request",
+			slog.String("method", r.Method),
+			slog.String("route", route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", sw.Status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", sw.Bytes),
+		)
+	})
+}
+
+type routeKey struct{}
+
+// Routed wraps the mux and publishes the pattern it matched to the middleware
+// further out. The mux sets r.Pattern on the request it receives, which is a
+// copy whenever a middleware in between called r.WithContext.
+func Routed(mux http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux.ServeHTTP(w, r)
+		if p, ok := r.Context().Value(routeKey{}).(*string); ok {
+			*p = r.Pattern
+		}
+	})
+}
+
+// Route returns the mux pattern that matched r, or "" if none did. Call it
+// after the mux has served the request.
+func Route(r *http.Request) string {
+	if r.Pattern != "" {
+		return r.Pattern
+	}
+	if p, ok := r.Context().Value(routeKey{}).(*string); ok {
+		return *p
+	}
+	return ""
+}
+
+// Method returns r.Method for the methods defined in RFC 9110 and RFC 5789,
+// and "OTHER" for anything else, so that metric labels and span names stay
+// bounded no matter what clients send.
+func Method(r *http.Request) string {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return r.Method
+	}
+	return "OTHER"
+}
+
+// StatusWriter records the status code and body size written through it.
+type StatusWriter struct {
+	http.ResponseWriter
+	Status int
+	Bytes  int
+
+	wroteHeader bool
+}
+
+// WrapWriter returns w as a *StatusWriter, wrapping it only once.
+func WrapWriter(w http.ResponseWriter) *StatusWriter {
+	if sw, ok := w.(*StatusWriter); ok {
+		return sw
+	}
+	return &StatusWriter{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (w *StatusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.Status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *StatusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.Bytes += n
+	return n, err
+}
+
+// Flush keeps streaming responses (exports, SSE) working through the wrapper.
+func (w *StatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *StatusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
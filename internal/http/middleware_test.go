@@ -0,0 +1,86 @@
+package httphelper
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gonesoft/go-dev-portfolio/internal/logging"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+
+	var handlerRequestID string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		handlerRequestID = RequestIDFromContext(r.Context())
+		logging.FromContext(r.Context()).Info("inside handler")
+		Error(w, http.StatusNotFound, "User not found")
+	})
+	// a middleware that copies the request must not hide the matched route
+	copying := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Routed(mux).ServeHTTP(w, r.WithContext(r.Context()))
+	})
+	handler := RequestID(AccessLog(logger, copying))
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set(RequestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, "req-123", rec.Header().Get(RequestIDHeader))
+	assert.Equal(t, "req-123", handlerRequestID)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(t, lines, 2)
+
+	var inner, access map[string]interface{}
+	_ = json.Unmarshal(lines[0], &inner)
+	_ = json.Unmarshal(lines[1], &access)
+	assert.Equal(t, "req-123", inner["request_id"], "Handler logger should carry the request ID")
+	assert.Equal(t, "http request", access["msg"])
+	assert.Equal(t, "GET", access["method"])
+	assert.Equal(t, "/users/", access["route"])
+	assert.Equal(t, float64(http.StatusNotFound), access["status"])
+	assert.Equal(t, float64(rec.Body.Len()), access["bytes"])
+	assert.Contains(t, access, "latency_ms")
+}
+
+func TestRequestIDIsGenerated(t *testing.T) {
+	rec := httptest.NewRecorder()
+	RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Len(t, rec.Header().Get(RequestIDHeader), 32)
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := trustedProxies("10.0.0.0/8, 192.0.2.7")
+	tests := []struct {
+		name       string
+		trustProxy bool
+		forwarded  []string
+		want       string
+	}{
+		{"Header ignored without a trusted proxy", false, []string{"203.0.113.9"}, "198.51.100.1"},
+		{"No header", true, nil, "198.51.100.1"},
+		{"Address added by the load balancer", true, []string{"203.0.113.9"}, "203.0.113.9"},
+		{"Spoofed entries on the left are ignored", true, []string{"1.2.3.4, 5.6.7.8, 203.0.113.9"}, "203.0.113.9"},
+		{"Trusted proxies on the right are skipped", true, []string{"1.2.3.4, 203.0.113.9, 10.1.2.3", "192.0.2.7"}, "203.0.113.9"},
+		{"Garbage stops the walk", true, []string{"203.0.113.9, bogus, 10.1.2.3"}, "bogus"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "198.51.100.1:4711"
+		for _, v := range tt.forwarded {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		assert.Equal(t, tt.want, ClientIP(req, tt.trustProxy, proxies), tt.name)
+	}
+}
@@ -0,0 +1,201 @@
+// Package idempotency makes mutating requests safe to retry. A request carrying
+// an Idempotency-Key header runs once; its response is stored in Postgres and
+// replayed for identical retries until the key expires. Keys are scoped to the
+// authenticated principal, so clients cannot collide on the same key.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/db"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/logging"
+)
+
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+
+	maxKeyLength = 255
+)
+
+// replayedHeaders are the response headers stored alongside status and body.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Middleware applies Idempotency-Key handling to POST, PUT, PATCH and DELETE
+// requests. Requests without the header pass straight through. The body is
+// buffered to be fingerprinted, up to IDEMPOTENCY_MAX_BODY_BYTES, which must be
+// at least the largest body a route accepts (imports, by default 10 MiB).
+// It must run inside auth.Middleware.
+func Middleware(next http.Handler) http.Handler {
+	ttl := config.Duration("IDEMPOTENCY_TTL", 24*time.Hour)
+	wait := config.Duration("IDEMPOTENCY_WAIT", 5*time.Second)
+	maxBodyBytes := int64(config.Int("IDEMPOTENCY_MAX_BODY_BYTES", 10<<20))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(Header)
+		if key == "" || !isMutating(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxKeyLength {
+			httphelper.Error(w, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			httphelper.DecodeError(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := requestHash(r, body)
+
+		database, err := db.Connect()
+		if err != nil {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+			return
+		}
+
+		principal := auth.FromContext(r.Context()).Subject
+		for attempt := 0; ; attempt++ {
+			claimed, err := claim(r.Context(), database, principal, key, hash, ttl)
+			if err != nil {
+				httphelper.Error(w, http.StatusInternalServerError, "Failed to check idempotency key")
+				return
+			}
+			if claimed {
+				break
+			}
+			// a duplicate that failed and released the key: run this one instead
+			if released := replayOrReject(w, r, database, principal, key, hash, wait, attempt > 0); !released {
+				return
+			}
+		}
+
+		// bookkeeping must finish even if the client hangs up mid-request
+		ctx := context.WithoutCancel(r.Context())
+		rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+		completed := false
+		defer func() {
+			// a panicking or failing handler must not leave the key locked
+			if !completed {
+				if err := release(ctx, database, principal, key); err != nil {
+					logging.FromContext(ctx).Error("release idempotency key", "error", err)
+				}
+			}
+		}()
+		next.ServeHTTP(rec, r)
+
+		// server errors are not stored: the deferred release frees the key
+		// so that a retry can run the request again
+		if rec.status >= http.StatusInternalServerError {
+			return
+		}
+		if err := complete(ctx, database, principal, key, rec); err != nil {
+			logging.FromContext(ctx).Error("store idempotent response", "error", err)
+			return
+		}
+		completed = true
+	})
+}
+
+// replayOrReject answers a request whose key is already claimed: replay the
+// stored response, reject a different payload, or wait for an in-flight
+// duplicate. It returns true without writing anything when the key was
+// released in the meantime and may be claimed again, unless lastTry is set.
+func replayOrReject(w http.ResponseWriter, r *http.Request, database *sql.DB, principal, key, hash string, wait time.Duration, lastTry bool) bool {
+	deadline := time.Now().Add(wait)
+	for {
+		stored, err := lookup(r.Context(), database, principal, key)
+		if err != nil {
+			httphelper.Error(w, http.StatusInternalServerError, "Failed to check idempotency key")
+			return false
+		}
+		if stored == nil {
+			if !lastTry {
+				return true
+			}
+			w.Header().Set("Retry-After", "1")
+			httphelper.Error(w, http.StatusConflict, "A request with this Idempotency-Key did not complete, retry")
+			return false
+		}
+		if stored.RequestHash != hash {
+			httphelper.Error(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request")
+			return false
+		}
+		if stored.Completed {
+			for name, value := range stored.Headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set(ReplayedHeader, "true")
+			w.WriteHeader(stored.Status)
+			_, _ = w.Write(stored.Body)
+			return false
+		}
+		if time.Now().After(deadline) {
+			w.Header().Set("Retry-After", "1")
+			httphelper.Error(w, http.StatusConflict, "A request with this Idempotency-Key is already in progress")
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func isMutating(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// requestHash fingerprints everything that makes two requests "the same".
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+	io.WriteString(h, r.Header.Get("If-Match")+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recorder passes the response through while keeping a copy to store.
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) headers() map[string]string {
+	out := map[string]string{}
+	for _, name := range replayedHeaders {
+		if v := r.Header().Get(name); v != "" {
+			out[name] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,171 @@
+package idempotency
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDatabase skips tests that need Postgres when it is not reachable.
+func testDatabase(t *testing.T) *sql.DB {
+	testDB, err := db.Connect()
+	if err == nil {
+		err = testDB.Ping()
+	}
+	if err != nil {
+		t.Skip("Postgres not available: ", err)
+	}
+	_, _ = testDB.Exec("DELETE FROM idempotency_keys")
+	return testDB
+}
+
+func TestIdempotencyKeyReplay(t *testing.T) {
+	testDatabase(t)
+	t.Setenv("AUTH_TRUST_HEADERS", "true")
+	t.Setenv("AUTH_GATEWAY_SECRET", "gateway")
+
+	var calls int32
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if strings.Contains(r.URL.Path, "fail") {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = io.WriteString(w, `{"call":`+strconv.Itoa(int(n))+`}`)
+	}))
+	ts := httptest.NewServer(auth.Middleware(handler))
+	defer ts.Close()
+
+	postAs := func(subject, path, key, body string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.SecretHeader, "gateway")
+		req.Header.Set(auth.SubjectHeader, subject)
+		if key != "" {
+			req.Header.Set(Header, key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+	post := func(path, key, body string) *http.Response {
+		return postAs("alice", path, key, body)
+	}
+
+	t.Run("Identical retry is replayed", func(t *testing.T) {
+		first := post("/users", "key-1", `{"name":"A"}`)
+		firstBody, _ := io.ReadAll(first.Body)
+		first.Body.Close()
+		assert.Equal(t, http.StatusCreated, first.StatusCode)
+
+		second := post("/users", "key-1", `{"name":"A"}`)
+		secondBody, _ := io.ReadAll(second.Body)
+		second.Body.Close()
+		assert.Equal(t, http.StatusCreated, second.StatusCode)
+		assert.Equal(t, "true", second.Header.Get(ReplayedHeader))
+		assert.Equal(t, "application/json", second.Header.Get("Content-Type"))
+		assert.Equal(t, string(firstBody), string(secondBody))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Handler should run once")
+	})
+
+	t.Run("Different payload returns 422", func(t *testing.T) {
+		resp := post("/users", "key-1", `{"name":"B"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	})
+
+	t.Run("Server errors are not stored", func(t *testing.T) {
+		before := atomic.LoadInt32(&calls)
+		resp := post("/fail", "key-2", `{}`)
+		resp.Body.Close()
+		resp = post("/fail", "key-2", `{}`)
+		resp.Body.Close()
+		assert.Equal(t, before+2, atomic.LoadInt32(&calls), "Failed request should run again on retry")
+	})
+
+	t.Run("Keys are scoped to the principal", func(t *testing.T) {
+		before := atomic.LoadInt32(&calls)
+		resp := postAs("bob", "/users", "key-1", `{"name":"B"}`)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode, "Another client's key-1 should not interfere")
+		assert.Empty(t, resp.Header.Get(ReplayedHeader))
+		assert.Equal(t, before+1, atomic.LoadInt32(&calls))
+	})
+
+	t.Run("Requests without a key are not tracked", func(t *testing.T) {
+		before := atomic.LoadInt32(&calls)
+		resp := post("/users", "", `{"name":"A"}`)
+		resp.Body.Close()
+		resp = post("/users", "", `{"name":"A"}`)
+		resp.Body.Close()
+		assert.Equal(t, before+2, atomic.LoadInt32(&calls))
+	})
+}
+
+func TestIdempotencyConcurrentDuplicates(t *testing.T) {
+	testDatabase(t)
+	t.Setenv("IDEMPOTENCY_WAIT", "200ms")
+
+	var calls int32
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(entered)
+		}
+		<-unblock
+		w.WriteHeader(http.StatusCreated)
+	}))
+	ts := httptest.NewServer(auth.Middleware(handler))
+	defer ts.Close()
+
+	post := func() int {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/users", strings.NewReader(`{"name":"A"}`))
+		req.Header.Set(Header, "parallel")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	statuses := make(chan int, 2)
+	go func() { statuses <- post() }()
+	<-entered
+	// the duplicate arrives while the first request is still running
+	go func() { statuses <- post() }()
+	second := <-statuses
+	close(unblock)
+	first := <-statuses
+
+	assert.Equal(t, http.StatusCreated, first)
+	assert.Equal(t, http.StatusConflict, second, "An in-flight duplicate should be refused")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Handler should run once")
+}
+
+func TestIdempotencyBodyLimit(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_MAX_BODY_BYTES", "16")
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Oversized request should not reach the handler")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader(strings.Repeat("x", 17)))
+	req.Header.Set(Header, "big")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -0,0 +1,105 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// storedResponse is a row of idempotency_keys.
+type storedResponse struct {
+	RequestHash string
+	Completed   bool
+	Status      int
+	Headers     map[string]string
+	Body        []byte
+}
+
+// claim reserves key of principal for this request. It returns false when a
+// live record already exists; expired records are taken over.
+func claim(ctx context.Context, db *sql.DB, principal, key, hash string, ttl time.Duration) (bool, error) {
+	var claimed string
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO idempotency_keys (principal, key, request_hash, expires_at)
+		VALUES ($4, $1, $2, NOW() + MAKE_INTERVAL(secs => $3))
+		ON CONFLICT (principal, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash,
+		    status_code = NULL,
+		    response_headers = NULL,
+		    response_body = NULL,
+		    response_hash = NULL,
+		    created_at = NOW(),
+		    completed_at = NULL,
+		    expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < NOW()
+		RETURNING key
+	`, key, hash, ttl.Seconds(), principal).Scan(&claimed)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lookup returns the live record for key of principal, or nil when there is none.
+func lookup(ctx context.Context, db *sql.DB, principal, key string) (*storedResponse, error) {
+	var (
+		stored  storedResponse
+		status  sql.NullInt64
+		headers []byte
+	)
+	err := db.QueryRowContext(ctx, `
+		SELECT request_hash, status_code, response_headers, response_body
+		FROM idempotency_keys
+		WHERE principal = $2 AND key = $1 AND expires_at >= NOW()
+	`, key, principal).Scan(&stored.RequestHash, &status, &headers, &stored.Body)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stored.Completed = status.Valid
+	stored.Status = int(status.Int64)
+	if len(headers) > 0 {
+		if err := json.Unmarshal(headers, &stored.Headers); err != nil {
+			return nil, err
+		}
+	}
+	return &stored, nil
+}
+
+// complete stores the recorded response for key of principal.
+func complete(ctx context.Context, db *sql.DB, principal, key string, rec *recorder) error {
+	headers, err := json.Marshal(rec.headers())
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status_code = $2, response_headers = $3, response_body = $4,
+		    response_hash = ENCODE(SHA256($4), 'hex'), completed_at = NOW()
+		WHERE principal = $5 AND key = $1
+	`, key, rec.status, headers, rec.body.Bytes(), principal)
+	return err
+}
+
+// release drops an unfinished claim so that the key can be used again.
+func release(ctx context.Context, db *sql.DB, principal, key string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys
+		WHERE principal = $2 AND key = $1 AND completed_at IS NULL`, key, principal)
+	return err
+}
+
+// PurgeExpired deletes records whose TTL has passed and returns how many were removed.
+func PurgeExpired(ctx context.Context, db *sql.DB) (int64, error) {
+	result, err := db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at < NOW()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
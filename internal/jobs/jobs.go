@@ -0,0 +1,160 @@
+// Package jobs is a durable background job queue stored in Postgres. Jobs are
+// enqueued in the caller's transaction, claimed by workers with
+// SELECT ... FOR UPDATE SKIP LOCKED so that any number of instances can share
+// the queue, and retried with backoff until they succeed or run out of
+// attempts.
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// DefaultMaxAttempts is how often a job runs before it is marked failed,
+// unless it was enqueued with MaxAttempts.
+const DefaultMaxAttempts = 5
+
+// Job is the payload of a job. Kind selects the handler and must be constant
+// for a type; the value is stored as JSON.
+type Job interface {
+	Kind() string
+}
+
+type options struct {
+	runAt       time.Time
+	maxAttempts int
+	uniqueKey   string
+}
+
+// Option changes how a job is enqueued.
+type Option func(*options)
+
+// RunAt delays the job until t.
+func RunAt(t time.Time) Option {
+	return func(o *options) { o.runAt = t }
+}
+
+// Delay delays the job by d.
+func Delay(d time.Duration) Option {
+	return func(o *options) { o.runAt = time.Now().Add(d) }
+}
+
+// MaxAttempts overrides DefaultMaxAttempts.
+func MaxAttempts(n int) Option {
+	return func(o *options) { o.maxAttempts = max(n, 1) }
+}
+
+// Unique skips the job while another job with the same key is pending or
+// running.
+func Unique(key string) Option {
+	return func(o *options) { o.uniqueKey = key }
+}
+
+// ErrUniqueBatch is returned by EnqueueAll when given Unique.
+var ErrUniqueBatch = errors.New("jobs: Unique cannot be used with EnqueueAll")
+
+func applyOptions(opts []Option) options {
+	o := options{maxAttempts: DefaultMaxAttempts}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// runAtArg is NULL, meaning "now" in the database's clock, unless the job was delayed.
+func (o options) runAtArg() interface{} {
+	if o.runAt.IsZero() {
+		return nil
+	}
+	return o.runAt
+}
+
+// Enqueue adds a job and returns its ID. Pass the transaction of the change
+// the job belongs to so that it only runs if that commits. A job skipped by
+// Unique returns ID 0 and no error.
+func Enqueue(ctx context.Context, q db.DBTX, job Job, opts ...Option) (int64, error) {
+	o := applyOptions(opts)
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return 0, err
+	}
+	var uniqueKey interface{}
+	if o.uniqueKey != "" {
+		uniqueKey = o.uniqueKey
+	}
+
+	var id int64
+	err = q.QueryRowContext(ctx, `
+		INSERT INTO jobs (kind, payload, max_attempts, unique_key, run_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5::timestamptz, NOW()))
+		ON CONFLICT (unique_key) WHERE status IN ('pending', 'running') DO NOTHING
+		RETURNING id
+	`, job.Kind(), payload, o.maxAttempts, uniqueKey, o.runAtArg()).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("enqueue %s job: %w", job.Kind(), err)
+	}
+	return id, nil
+}
+
+// enqueueChunkSize keeps multi-row INSERTs well below Postgres' 65535 parameter limit.
+const enqueueChunkSize = 1000
+
+// EnqueueAll adds many jobs with multi-row INSERTs, for bulk operations that
+// would otherwise enqueue one job per row.
+func EnqueueAll(ctx context.Context, q db.DBTX, jobs []Job, opts ...Option) error {
+	o := applyOptions(opts)
+	if o.uniqueKey != "" {
+		return ErrUniqueBatch
+	}
+	for start := 0; start < len(jobs); start += enqueueChunkSize {
+		chunk := jobs[start:min(start+enqueueChunkSize, len(jobs))]
+		values := make([]string, len(chunk))
+		args := []interface{}{o.maxAttempts, o.runAtArg()}
+		for i, job := range chunk {
+			payload, err := json.Marshal(job)
+			if err != nil {
+				return err
+			}
+			n := len(args)
+			values[i] = fmt.Sprintf("($%d, $%d, $1, COALESCE($2::timestamptz, NOW()))", n+1, n+2)
+			args = append(args, job.Kind(), payload)
+		}
+		_, err := q.ExecContext(ctx, `
+			INSERT INTO jobs (kind, payload, max_attempts, run_at)
+			VALUES `+strings.Join(values, ", "), args...)
+		if err != nil {
+			return fmt.Errorf("enqueue jobs: %w", err)
+		}
+	}
+	return nil
+}
+
+// PurgeFinished deletes succeeded and failed jobs that finished before
+// olderThan and returns how many were removed.
+func PurgeFinished(ctx context.Context, q db.DBTX, olderThan time.Duration) (int64, error) {
+	result, err := q.ExecContext(ctx, `
+		DELETE FROM jobs
+		WHERE status IN ('succeeded', 'failed') AND finished_at < NOW() - MAKE_INTERVAL(secs => $1)
+	`, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,161 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type greet struct {
+	Name string `json:"name"`
+}
+
+func (greet) Kind() string { return "test.greet" }
+
+func jobStatus(t *testing.T, id int64) (status string, attempts int, lastError string) {
+	conn, _ := db.Connect()
+	err := conn.QueryRow("SELECT status, attempts, COALESCE(last_error, '') FROM jobs WHERE id = $1", id).
+		Scan(&status, &attempts, &lastError)
+	assert.NoError(t, err)
+	return status, attempts, lastError
+}
+
+// runUntil runs w until cond holds or a few seconds have passed.
+func runUntil(t *testing.T, w *Worker, cond func() bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	assert.True(t, cond(), "condition not met before the deadline")
+}
+
+func TestEnqueue(t *testing.T) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("DELETE FROM jobs")
+	ctx := context.Background()
+
+	id, err := Enqueue(ctx, conn, greet{Name: "Ann"}, Unique("greet:ann"))
+	assert.NoError(t, err)
+	assert.NotZero(t, id)
+
+	dup, err := Enqueue(ctx, conn, greet{Name: "Ann"}, Unique("greet:ann"))
+	assert.NoError(t, err)
+	assert.Zero(t, dup, "A unique job should be skipped while one is pending")
+
+	err = EnqueueAll(ctx, conn, []Job{greet{Name: "Bo"}, greet{Name: "Cy"}}, Delay(time.Hour))
+	assert.NoError(t, err)
+	assert.ErrorIs(t, EnqueueAll(ctx, conn, []Job{greet{}}, Unique("x")), ErrUniqueBatch)
+
+	var due, delayed int
+	_ = conn.QueryRow("SELECT COUNT(*) FILTER (WHERE run_at <= NOW()), COUNT(*) FILTER (WHERE run_at > NOW()) FROM jobs").
+		Scan(&due, &delayed)
+	assert.Equal(t, 1, due)
+	assert.Equal(t, 2, delayed)
+}
+
+func TestWorkerRunsJobs(t *testing.T) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("DELETE FROM jobs")
+	ctx := context.Background()
+
+	var greeted atomic.Int64
+	w := NewWorker(conn, 2)
+	Handle(w, func(ctx context.Context, job greet) error {
+		if job.Name == "" {
+			return errors.New("no name")
+		}
+		greeted.Add(1)
+		return nil
+	})
+
+	var ids []int64
+	for _, name := range []string{"Ann", "Bo", "Cy"} {
+		id, err := Enqueue(ctx, conn, greet{Name: name})
+		assert.NoError(t, err)
+		ids = append(ids, id)
+	}
+	runUntil(t, w, func() bool { return greeted.Load() == 3 })
+	for _, id := range ids {
+		status, attempts, _ := jobStatus(t, id)
+		assert.Equal(t, StatusSucceeded, status)
+		assert.Equal(t, 1, attempts)
+	}
+
+	// a unique key is free again once its job has finished
+	id, err := Enqueue(ctx, conn, greet{Name: "Ann"}, Unique("greet:ann"))
+	assert.NoError(t, err)
+	assert.NotZero(t, id)
+}
+
+func TestWorkerRetriesAndFails(t *testing.T) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("DELETE FROM jobs")
+	ctx := context.Background()
+
+	var calls atomic.Int64
+	w := NewWorker(conn, 1)
+	w.Backoff.Initial, w.Backoff.Max, w.Backoff.Jitter = 10*time.Millisecond, 10*time.Millisecond, 0
+	Handle(w, func(ctx context.Context, job greet) error {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		return errors.New("still broken")
+	})
+
+	id, err := Enqueue(ctx, conn, greet{Name: "Ann"}, MaxAttempts(3))
+	assert.NoError(t, err)
+	runUntil(t, w, func() bool {
+		status, _, _ := jobStatus(t, id)
+		return status == StatusFailed
+	})
+
+	status, attempts, lastError := jobStatus(t, id)
+	assert.Equal(t, StatusFailed, status)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, "still broken", lastError)
+	assert.Equal(t, int64(3), calls.Load(), "A panicking job should be retried like a failing one")
+}
+
+func TestWorkerShutdownReleasesJobs(t *testing.T) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("DELETE FROM jobs")
+
+	started := make(chan struct{})
+	w := NewWorker(conn, 1)
+	w.ShutdownTimeout = 50 * time.Millisecond
+	Handle(w, func(ctx context.Context, job greet) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	id, err := Enqueue(context.Background(), conn, greet{Name: "Ann"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	<-started
+	cancel()
+	<-done
+
+	status, attempts, _ := jobStatus(t, id)
+	assert.Equal(t, StatusPending, status, "A job cancelled by shutdown should go back to the queue")
+	assert.Equal(t, 0, attempts)
+}
@@ -0,0 +1,238 @@
+package jobs
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/backoff"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+)
+
+// claimQuery takes due pending jobs, and running jobs whose lease expired
+// because their worker died, and marks them running.
+const claimQuery = `
+	UPDATE jobs SET status = 'running', attempts = attempts + 1, locked_at = NOW()
+	WHERE id IN (
+		SELECT id FROM jobs
+		WHERE (status = 'pending' AND run_at <= NOW())
+			OR (status = 'running' AND locked_at < NOW() - MAKE_INTERVAL(secs => $2))
+		ORDER BY run_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, kind, payload, attempts, max_attempts`
+
+type claimed struct {
+	id          int64
+	kind        string
+	payload     json.RawMessage
+	attempts    int
+	maxAttempts int
+}
+
+type handlerFunc func(ctx context.Context, payload json.RawMessage) error
+
+// Worker runs jobs with a bounded pool of goroutines.
+type Worker struct {
+	db          *sql.DB
+	handlers    map[string]handlerFunc
+	concurrency int
+
+	// Timeout bounds a single run of a job; a job still running after
+	// Timeout plus a minute is considered abandoned and run again.
+	Timeout time.Duration
+	// ShutdownTimeout is how long Run waits for running jobs once its
+	// context is done before cancelling them.
+	ShutdownTimeout time.Duration
+	// Backoff spaces out the retries of a failing job.
+	Backoff backoff.Policy
+
+	active atomic.Int64
+	freed  chan struct{}
+}
+
+// NewWorker returns a Worker running up to concurrency jobs at a time.
+func NewWorker(database *sql.DB, concurrency int) *Worker {
+	return &Worker{
+		db:              database,
+		handlers:        map[string]handlerFunc{},
+		concurrency:     max(concurrency, 1),
+		Timeout:         5 * time.Minute,
+		ShutdownTimeout: 30 * time.Second,
+		Backoff:         backoff.Policy{Initial: 5 * time.Second, Max: time.Hour, Multiplier: 2, Jitter: 0.2},
+		freed:           make(chan struct{}, 1),
+	}
+}
+
+// Handle registers fn for jobs of type T. Register every handler before Run.
+func Handle[T Job](w *Worker, fn func(ctx context.Context, job T) error) {
+	var zero T
+	w.handlers[zero.Kind()] = func(ctx context.Context, payload json.RawMessage) error {
+		var job T
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return fmt.Errorf("decode job: %w", err)
+		}
+		return fn(ctx, job)
+	}
+}
+
+// Run claims and runs jobs until ctx is done, polling every interval while
+// the queue is empty. It then stops claiming and waits for running jobs;
+// jobs still running after ShutdownTimeout are cancelled and put back in the
+// queue without counting the attempt.
+func (w *Worker) Run(ctx context.Context, interval time.Duration) {
+	jobCtx, cancelJobs := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelJobs()
+	var running sync.WaitGroup
+
+	for ctx.Err() == nil {
+		free := w.concurrency - int(w.active.Load())
+		n := 0
+		if free > 0 {
+			batch, err := w.claim(ctx, free)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("claim jobs", "error", err)
+			}
+			for _, j := range batch {
+				w.active.Add(1)
+				running.Add(1)
+				go func() {
+					defer running.Done()
+					defer w.release()
+					w.execute(jobCtx, j)
+				}()
+			}
+			n = len(batch)
+		}
+
+		// with every slot busy, or a full batch claimed, go again as soon as
+		// a job finishes; with the queue drained, poll
+		var timer *time.Timer
+		var poll <-chan time.Time
+		if free > 0 && n < free {
+			timer = time.NewTimer(interval)
+			poll = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-w.freed:
+		case <-poll:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(w.ShutdownTimeout):
+		slog.Warn("jobs still running at shutdown, cancelling", "count", w.active.Load())
+		cancelJobs()
+		<-done
+	}
+}
+
+func (w *Worker) release() {
+	w.active.Add(-1)
+	select {
+	case w.freed <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Worker) claim(ctx context.Context, limit int) ([]claimed, error) {
+	lease := w.Timeout + time.Minute
+	rows, err := w.db.QueryContext(ctx, claimQuery, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batch []claimed
+	for rows.Next() {
+		var j claimed
+		if err := rows.Scan(&j.id, &j.kind, &j.payload, &j.attempts, &j.maxAttempts); err != nil {
+			return nil, err
+		}
+		batch = append(batch, j)
+	}
+	return batch, rows.Err()
+}
+
+// execute runs one job and records the outcome.
+func (w *Worker) execute(ctx context.Context, j claimed) {
+	start := time.Now()
+	err := w.call(ctx, j)
+	took := time.Since(start)
+	metrics.JobDuration.WithLabelValues(j.kind).Observe(took.Seconds())
+
+	// record the outcome even when the job was cancelled
+	recordCtx := context.WithoutCancel(ctx)
+	logger := slog.With("job_id", j.id, "kind", j.kind, "attempt", j.attempts)
+	var result string
+	var dbErr error
+	switch {
+	case err == nil:
+		result = StatusSucceeded
+		_, dbErr = w.db.ExecContext(recordCtx, `
+			UPDATE jobs SET status = 'succeeded', finished_at = NOW(), locked_at = NULL, last_error = NULL
+			WHERE id = $1
+		`, j.id)
+		logger.Debug("job succeeded", "duration_ms", took.Milliseconds())
+	case ctx.Err() != nil:
+		// cancelled by shutdown: this attempt does not count
+		result = "cancelled"
+		_, dbErr = w.db.ExecContext(recordCtx, `
+			UPDATE jobs SET status = 'pending', attempts = attempts - 1, locked_at = NULL, run_at = NOW()
+			WHERE id = $1
+		`, j.id)
+		logger.Info("job cancelled by shutdown")
+	case j.attempts >= j.maxAttempts:
+		result = StatusFailed
+		_, dbErr = w.db.ExecContext(recordCtx, `
+			UPDATE jobs SET status = 'failed', finished_at = NOW(), locked_at = NULL, last_error = $2
+			WHERE id = $1
+		`, j.id, err.Error())
+		logger.Error("job failed permanently", "error", err)
+	default:
+		result = "retry"
+		_, dbErr = w.db.ExecContext(recordCtx, `
+			UPDATE jobs SET status = 'pending', locked_at = NULL, last_error = $2,
+				run_at = NOW() + MAKE_INTERVAL(secs => $3)
+			WHERE id = $1
+		`, j.id, err.Error(), w.Backoff.Delay(j.attempts-1).Seconds())
+		logger.Warn("job failed, will retry", "error", err)
+	}
+	metrics.JobsProcessed.WithLabelValues(j.kind, result).Inc()
+	if dbErr != nil {
+		// the lease expires and the job runs again
+		logger.Error("record job result", "result", result, "error", dbErr)
+	}
+}
+
+// call runs the handler of j with the job timeout, turning panics into errors.
+func (w *Worker) call(ctx context.Context, j claimed) (err error) {
+	handler, ok := w.handlers[j.kind]
+	if !ok {
+		return fmt.Errorf("no handler registered for job kind %q", j.kind)
+	}
+	ctx, cancel := context.WithTimeout(ctx, w.Timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("job panicked: %v", p)
+		}
+	}()
+	return handler(ctx, j.payload)
+}
@@ -0,0 +1,123 @@
+// Package loadshed caps the number of requests the server works on at once
+// and rejects the rest with 503, so that an overloaded server keeps answering
+// quickly instead of queueing until everything times out.
+//
+// The cap adapts to latency in the manner of Netflix's gradient limiter: it
+// compares a short-term average of request latency with a long-term one. While
+// they agree the cap grows; when requests slow down (usually because something
+// downstream, like the database, is saturated) it shrinks.
+package loadshed
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+)
+
+const (
+	// tolerance is how much slower than the long-term average requests may
+	// get before the cap shrinks.
+	tolerance = 1.5
+	// smoothing weights a new cap estimate against the current cap.
+	smoothing  = 0.2
+	shortAlpha = 0.1
+	longAlpha  = 0.01
+)
+
+// Limiter holds the adaptive concurrency cap.
+type Limiter struct {
+	mu       sync.Mutex
+	limit    float64
+	min, max float64
+	inFlight int
+	// latency averages in seconds; zero until the first sample
+	shortRTT, longRTT float64
+}
+
+// New returns a Limiter starting at initial concurrent requests and adapting
+// between min and max.
+func New(initial, min, max int) *Limiter {
+	l := &Limiter{limit: float64(initial), min: float64(min), max: float64(max)}
+	metrics.ConcurrencyLimit.Set(l.limit)
+	return l
+}
+
+// Acquire admits a request if fewer than the cap are in flight. The caller
+// must call release with the request's latency when it is done.
+func (l *Limiter) Acquire() (release func(latency time.Duration), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if float64(l.inFlight) >= math.Floor(l.limit) {
+		return nil, false
+	}
+	l.inFlight++
+	inFlight := l.inFlight
+	return func(latency time.Duration) { l.sample(latency, inFlight) }, true
+}
+
+// Limit returns the current cap.
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// sample records the latency of a request that ran with inFlight requests in
+// flight and moves the cap.
+func (l *Limiter) sample(latency time.Duration, inFlight int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight--
+
+	rtt := max(latency.Seconds(), 1e-6)
+	if l.longRTT == 0 {
+		l.shortRTT, l.longRTT = rtt, rtt
+		return
+	}
+	l.shortRTT += shortAlpha * (rtt - l.shortRTT)
+	l.longRTT += longAlpha * (rtt - l.longRTT)
+	// after a slow spell the long-term average lags; let it catch up so the
+	// cap can recover
+	if l.longRTT/l.shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+
+	gradient := max(0.5, min(1, tolerance*l.longRTT/l.shortRTT))
+	estimate := l.limit*gradient + math.Sqrt(l.limit)
+	// a server far below its cap says nothing about whether it could take more
+	if estimate > l.limit && float64(inFlight) < l.limit/2 {
+		return
+	}
+	l.limit = max(l.min, min(l.max, l.limit*(1-smoothing)+estimate*smoothing))
+	metrics.ConcurrencyLimit.Set(l.limit)
+}
+
+// Middleware sheds requests over the cap with 503 and Retry-After: 1. Paths in
+// exempt, such as probes and long-lived streams, are neither limited nor
+// sampled.
+func (l *Limiter) Middleware(next http.Handler, exempt ...string) http.Handler {
+	skip := make(map[string]bool, len(exempt))
+	for _, path := range exempt {
+		skip[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		release, ok := l.Acquire()
+		if !ok {
+			metrics.RequestsShed.Inc()
+			w.Header().Set("Retry-After", "1")
+			httphelper.Error(w, http.StatusServiceUnavailable, "Server overloaded, try again later")
+			return
+		}
+		start := time.Now()
+		defer func() { release(time.Since(start)) }()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,79 @@
+package loadshed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterRejectsOverLimit(t *testing.T) {
+	l := New(2, 1, 10)
+	r1, ok := l.Acquire()
+	require.True(t, ok)
+	_, ok = l.Acquire()
+	require.True(t, ok)
+	_, ok = l.Acquire()
+	assert.False(t, ok, "Third concurrent request should be shed")
+
+	r1(10 * time.Millisecond)
+	_, ok = l.Acquire()
+	assert.True(t, ok, "Released slots should be reusable")
+}
+
+func TestLimiterAdaptsToLatency(t *testing.T) {
+	l := New(20, 5, 100)
+	run := func(latency time.Duration, n int) {
+		for range n {
+			var releases []func(time.Duration)
+			// keep the server busy so that growth is not ruled out
+			for range l.Limit() {
+				release, ok := l.Acquire()
+				if !ok {
+					break
+				}
+				releases = append(releases, release)
+			}
+			for _, release := range releases {
+				release(latency)
+			}
+		}
+	}
+
+	run(10*time.Millisecond, 20)
+	grown := l.Limit()
+	assert.Greater(t, grown, 20, "Limit should grow while latency is steady")
+
+	run(200*time.Millisecond, 1)
+	assert.Less(t, l.Limit(), grown, "Limit should shrink when latency rises")
+	assert.GreaterOrEqual(t, l.Limit(), 5)
+}
+
+func TestMiddleware(t *testing.T) {
+	l := New(1, 1, 1)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	handler := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-block
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}), "/healthz")
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	<-started
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusNoContent, rec.Code, "Exempt paths should not be shed")
+	close(block)
+}
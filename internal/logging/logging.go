@@ -0,0 +1,72 @@
+// Package logging configures the application's log/slog logger and carries a
+// request-scoped logger through context.Context.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+)
+
+// Redacted replaces the value of attributes that carry personal data.
+const Redacted = "[REDACTED]"
+
+// piiKeys are attribute keys whose values are redacted unless LOG_PII=true.
+var piiKeys = map[string]bool{
+	"email": true,
+	"name":  true,
+	"to":    true,
+}
+
+type ctxKey struct{}
+
+// Setup builds the logger from the environment, installs it as the slog and
+// log package default and returns it.
+func Setup() *slog.Logger {
+	logger := New(os.Stdout)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// New returns a logger writing to w. APP_ENV=production selects JSON output,
+// anything else human readable text. LOG_LEVEL sets the minimum level and
+// LOG_PII=true disables redaction of personal data.
+func New(w io.Writer) *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(config.String("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	if !config.Bool("LOG_PII", false) {
+		opts.ReplaceAttr = redact
+	}
+
+	if config.String("APP_ENV", "development") == "production" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if piiKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// FromContext returns the logger stored in ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
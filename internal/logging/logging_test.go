@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRedactsPII(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("LOG_PII", "false")
+
+	var buf bytes.Buffer
+	New(&buf).Info("user created", "user_id", 7, "email", "jane@example.com")
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &entry), "Production logs should be JSON")
+	assert.Equal(t, "user created", entry["msg"])
+	assert.Equal(t, float64(7), entry["user_id"])
+	assert.Equal(t, Redacted, entry["email"])
+
+	buf.Reset()
+	New(&buf).Info("email", "to", "jane@example.com")
+	assert.NotContains(t, buf.String(), "jane@example.com", "Mail recipients are personal data too")
+}
+
+func TestNewKeepsPIIWhenEnabled(t *testing.T) {
+	t.Setenv("APP_ENV", "production")
+	t.Setenv("LOG_PII", "true")
+
+	var buf bytes.Buffer
+	New(&buf).Info("user created", "email", "jane@example.com")
+	assert.Contains(t, buf.String(), "jane@example.com")
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, slog.Default(), FromContext(context.Background()), "Missing logger falls back to the default")
+
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	ctx := WithContext(context.Background(), logger)
+	assert.Equal(t, logger, FromContext(ctx))
+}
@@ -0,0 +1,69 @@
+// Package mail sends transactional email.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends messages.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// LogMailer logs messages instead of sending them, for development.
+type LogMailer struct {
+	Logger *slog.Logger
+}
+
+func (l LogMailer) Send(ctx context.Context, m Message) error {
+	l.Logger.InfoContext(ctx, "email", "email", m.To, "subject", m.Subject)
+	return nil
+}
+
+// SMTPMailer sends messages through an SMTP relay at Addr (host:port),
+// authenticating with PLAIN auth when Username is set.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s SMTPMailer) Send(ctx context.Context, m Message) error {
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("mail: header contains a line break")
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	msg := "From: " + s.From + "\r\n" +
+		"To: " + m.To + "\r\n" +
+		"Subject: " + m.Subject + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + strings.ReplaceAll(m.Body, "\n", "\r\n")
+
+	// net/smtp takes no context; the job timeout bounds the call instead
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, s.From, []string{m.To}, []byte(msg)) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,23 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"gonesoft/go-dev-portfolio/internal/logging"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogMailerRedactsRecipient(t *testing.T) {
+	t.Setenv("LOG_PII", "false")
+
+	var buf bytes.Buffer
+	err := LogMailer{Logger: logging.New(&buf)}.Send(context.Background(),
+		Message{To: "jane@example.com", Subject: "Welcome", Body: "Hi"})
+	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "jane@example.com")
+	assert.Contains(t, buf.String(), logging.Redacted)
+	assert.Contains(t, buf.String(), "Welcome")
+}
@@ -0,0 +1,165 @@
+// Package metrics exposes the application's Prometheus metrics: HTTP traffic,
+// database pool and query latency, and user lifecycle counters.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds every metric served on /metrics.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests by method, route pattern and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route pattern and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	QueryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Latency of repository operations.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	UsersCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "users_created_total",
+		Help: "Users created.",
+	})
+
+	UsersDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "users_deleted_total",
+		Help: "Users soft-deleted.",
+	})
+
+	EventsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_published_total",
+		Help: "Outbox publish attempts by event type and result (ok, error).",
+	}, []string{"type", "result"})
+
+	WebhookDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "webhook_delivery_attempts_total",
+		Help: "Webhook delivery attempts by result (succeeded, failed, dead).",
+	}, []string{"result"})
+
+	JobsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "jobs_processed_total",
+		Help: "Background job runs by kind and result (succeeded, retry, failed, cancelled).",
+	}, []string{"kind", "result"})
+
+	JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "job_duration_seconds",
+		Help:    "Background job run time by kind.",
+		Buckets: []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	}, []string{"kind"})
+
+	RateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limited_total",
+		Help: "Requests rejected with 429 by rate limit policy.",
+	}, []string{"policy"})
+
+	CacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Cache lookups by cache and result (hit, miss, error).",
+	}, []string{"cache", "result"})
+
+	CacheLoads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_loads_total",
+		Help: "Values loaded on a miss; concurrent misses for a key share one load.",
+	}, []string{"cache"})
+
+	BreakerState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "Circuit breaker state by breaker (0 closed, 1 open, 2 half-open).",
+	}, []string{"breaker"})
+
+	BreakerRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "circuit_breaker_rejected_total",
+		Help: "Calls rejected by an open circuit breaker.",
+	}, []string{"breaker"})
+
+	ConcurrencyLimit = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "http_concurrency_limit",
+		Help: "Current adaptive limit on concurrent requests.",
+	})
+
+	RequestsShed = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "http_requests_shed_total",
+		Help: "Requests rejected with 503 because the concurrency limit was reached.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequests,
+		HTTPDuration,
+		QueryDuration,
+		UsersCreated,
+		UsersDeleted,
+		EventsPublished,
+		WebhookDeliveries,
+		JobsProcessed,
+		JobDuration,
+		RateLimited,
+		CacheRequests,
+		CacheLoads,
+		BreakerState,
+		BreakerRejected,
+		ConcurrencyLimit,
+		RequestsShed,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RegisterDB exposes the pool statistics of database (open, in use, idle,
+// wait count and wait duration) under the given name.
+func RegisterDB(database *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(database, name))
+}
+
+// ObserveQuery records the latency of a repository operation started at start:
+//
+//	defer metrics.ObserveQuery("get_user_by_id", time.Now())
+func ObserveQuery(operation string, start time.Time) {
+	QueryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// Middleware counts requests and records their latency. Routes are labelled by
+// the mux pattern rather than the raw path, and unknown methods as OTHER, to
+// keep cardinality bounded.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := httphelper.WrapWriter(w)
+		next.ServeHTTP(sw, r)
+
+		route := httphelper.Route(r)
+		if route == "" {
+			route = "unmatched"
+		}
+		method := httphelper.Method(r)
+		status := strconv.Itoa(sw.Status)
+		HTTPRequests.WithLabelValues(method, route, status).Inc()
+		HTTPDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddlewareAndHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	handler := Middleware(mux)
+
+	for _, path := range []string{"/users/1", "/users/2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	for _, method := range []string{"FOO1", "FOO2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(method, "/users/1", nil))
+	}
+	ObserveQuery("get_user_by_id", time.Now().Add(-5*time.Millisecond))
+	UsersCreated.Inc()
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+
+	assert.Contains(t, out, `http_requests_total{method="GET",route="/users/",status="404"} 2`,
+		"Requests should be labelled by route pattern, not raw path")
+	assert.Contains(t, out, `http_request_duration_seconds_count{method="GET",route="/users/",status="404"} 2`)
+	assert.Contains(t, out, `http_requests_total{method="OTHER",route="/users/",status="404"} 2`,
+		"Unknown methods should share one label")
+	assert.NotContains(t, out, `method="FOO1"`)
+	assert.Contains(t, out, `db_query_duration_seconds_count{operation="get_user_by_id"} 1`)
+	assert.Contains(t, out, "users_created_total 1")
+}
@@ -0,0 +1,60 @@
+package openapi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// refs returns every $ref in v.
+func refs(v any) []string {
+	var out []string
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if ref, ok := value.(string); ok && key == "$ref" {
+				out = append(out, ref)
+				continue
+			}
+			out = append(out, refs(value)...)
+		}
+	case []any:
+		for _, value := range v {
+			out = append(out, refs(value)...)
+		}
+	}
+	return out
+}
+
+func TestDocument(t *testing.T) {
+	var doc map[string]any
+	require.NoError(t, json.Unmarshal(Document, &doc))
+	assert.Equal(t, "3.1.0", doc["openapi"])
+
+	for _, ref := range refs(doc) {
+		require.True(t, strings.HasPrefix(ref, "#/"), ref)
+		var node any = doc
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, ok := node.(map[string]any)
+			require.True(t, ok, "unresolved $ref %s", ref)
+			node, ok = m[part]
+			require.True(t, ok, "unresolved $ref %s", ref)
+		}
+	}
+
+	ids := map[string]bool{}
+	for path, item := range doc["paths"].(map[string]any) {
+		for method, op := range item.(map[string]any) {
+			if method == "parameters" {
+				continue
+			}
+			id, _ := op.(map[string]any)["operationId"].(string)
+			assert.NotEmpty(t, id, "%s %s has no operationId", method, path)
+			assert.False(t, ids[id], "duplicate operationId %s", id)
+			ids[id] = true
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package patch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergePatch(t *testing.T) {
+	cases := []struct {
+		name, doc, patch, want string
+	}{
+		{"replace field", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"add field", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null removes", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"nested object", `{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{"array replaced", `{"a":["b"]}`, `{"a":["c","d"]}`, `{"a":["c","d"]}`},
+		{"non-object patch", `{"a":"b"}`, `["c"]`, `["c"]`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := MergePatch([]byte(tc.doc), []byte(tc.patch))
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.want, string(got))
+		})
+	}
+
+	_, err := MergePatch([]byte(`{}`), []byte(`{`))
+	assert.ErrorIs(t, err, ErrInvalidPatch, "Malformed merge patch should be rejected")
+}
+
+func TestJSONPatch(t *testing.T) {
+	cases := []struct {
+		name, doc, patch, want string
+	}{
+		{"add member", `{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"foo":"bar","baz":"qux"}`},
+		{"add array element", `{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{"append with dash", `{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":"baz"}]`, `{"foo":["bar","baz"]}`},
+		{"remove member", `{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{"remove array element", `{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{"replace", `{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{"move", `{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`, `{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{"copy", `{"a":{"b":1}}`, `[{"op":"copy","from":"/a","path":"/c"}]`, `{"a":{"b":1},"c":{"b":1}}`},
+		{"test then replace", `{"name":"Ann"}`, `[{"op":"test","path":"/name","value":"Ann"},{"op":"replace","path":"/name","value":"Bo"}]`, `{"name":"Bo"}`},
+		{"replace with null", `{"name":"Ann","phone":"555"}`, `[{"op":"replace","path":"/phone","value":null}]`, `{"name":"Ann","phone":null}`},
+		{"add null", `{"name":"Ann"}`, `[{"op":"add","path":"/phone","value":null}]`, `{"name":"Ann","phone":null}`},
+		{"test null", `{"phone":null}`, `[{"op":"test","path":"/phone","value":null}]`, `{"phone":null}`},
+		{"escaped pointer", `{"a/b":1,"m~n":2}`, `[{"op":"replace","path":"/a~1b","value":3},{"op":"remove","path":"/m~0n"}]`, `{"a/b":3}`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := JSONPatch([]byte(tc.doc), []byte(tc.patch))
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.want, string(got))
+		})
+	}
+}
+
+func TestJSONPatchErrors(t *testing.T) {
+	doc := []byte(`{"name":"Ann","tags":["a"]}`)
+
+	_, err := JSONPatch(doc, []byte(`[{"op":"test","path":"/name","value":"Bo"}]`))
+	assert.ErrorIs(t, err, ErrTestFailed)
+
+	_, err = JSONPatch(doc, []byte(`[{"op":"replace","path":"/missing","value":1}]`))
+	assert.ErrorIs(t, err, ErrPathNotFound)
+
+	_, err = JSONPatch(doc, []byte(`[{"op":"remove","path":"/tags/5"}]`))
+	assert.ErrorIs(t, err, ErrPathNotFound)
+
+	_, err = JSONPatch(doc, []byte(`[{"op":"frobnicate","path":"/name"}]`))
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+
+	_, err = JSONPatch(doc, []byte(`[{"op":"add","path":"/name"}]`))
+	assert.ErrorIs(t, err, ErrInvalidPatch, "add without value should be rejected")
+
+	_, err = JSONPatch(doc, []byte(`{"op":"add"}`))
+	assert.ErrorIs(t, err, ErrInvalidPatch, "patch must be an array")
+
+	// a failed operation leaves no partial result behind
+	_, err = JSONPatch(doc, []byte(`[{"op":"replace","path":"/name","value":"Bo"},{"op":"test","path":"/name","value":"Ann"}]`))
+	assert.ErrorIs(t, err, ErrTestFailed)
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	policy  Policy
+}
+
+// sweepEvery is how many calls to Take pass between sweeps of full buckets.
+const sweepEvery = 10000
+
+// MemoryStore keeps buckets in process memory. Every instance limits on its
+// own, so use it with a single instance only.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	calls   int
+	now     func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+
+	m.calls++
+	if m.calls%sweepEvery == 0 {
+		m.sweep(now)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(p.Limit), updated: now}
+		m.buckets[key] = b
+	}
+	b.policy = p
+	b.tokens = min(float64(p.Limit), b.tokens+now.Sub(b.updated).Seconds()*p.rate())
+	b.updated = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return result(p, b.tokens, allowed), nil
+}
+
+// sweep drops buckets that have refilled completely; they are the same as
+// no bucket at all.
+func (m *MemoryStore) sweep(now time.Time) {
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.policy.rate() >= float64(b.policy.Limit) {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+)
+
+// Limiter picks the policy of a request and takes a token for its client.
+type Limiter struct {
+	store    Store
+	fallback Policy
+	routes   *http.ServeMux
+	policies map[string]Policy
+}
+
+// NewLimiter returns a Limiter applying fallback to every route without a
+// policy of its own.
+func NewLimiter(store Store, fallback Policy) *Limiter {
+	return &Limiter{store: store, fallback: fallback, routes: http.NewServeMux(), policies: map[string]Policy{}}
+}
+
+// Route gives requests matching pattern, a ServeMux pattern such as
+// "POST /users", their own policy and bucket.
+func (l *Limiter) Route(pattern string, p Policy) (err error) {
+	defer func() {
+		// ServeMux panics on invalid or conflicting patterns
+		if v := recover(); v != nil {
+			err = fmt.Errorf("rate limit route %q: %v", pattern, v)
+		}
+	}()
+	l.routes.Handle(pattern, http.NotFoundHandler())
+	p.Name = pattern
+	l.policies[pattern] = p
+	return nil
+}
+
+func (l *Limiter) policy(r *http.Request) Policy {
+	if _, pattern := l.routes.Handler(r); pattern != "" {
+		return l.policies[pattern]
+	}
+	return l.fallback
+}
+
+// ClientKey identifies the client of r: the authenticated subject, else the
+// client IP. Nothing the client can set freely goes into the key, or it could
+// get a fresh bucket on every request.
+func ClientKey(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p.Subject != auth.Anonymous {
+		return "user:" + p.Subject
+	}
+	return "ip:" + httphelper.ClientIPFromContext(r.Context())
+}
+
+// Middleware answers 429 once a client has used up its bucket and sets the
+// RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy
+// headers on every limited response, plus Retry-After on a 429. It needs the
+// principal and client IP in the context, so it must run inside
+// auth.Middleware and httphelper.RequestID. If the store fails the request is
+// let through.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := l.policy(r)
+		if p.Disabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res, err := l.store.Take(r.Context(), p.Name+"|"+ClientKey(r), p)
+		if err != nil {
+			slog.Warn("rate limit store unavailable, not limiting", "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, ceilSeconds(p.Period)))
+		if !res.Allowed {
+			metrics.RateLimited.WithLabelValues(p.Name).Inc()
+			h.Set("Retry-After", strconv.Itoa(max(ceilSeconds(res.RetryAfter), 1)))
+			httphelper.Error(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,82 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	limiter := NewLimiter(NewMemoryStore(), Policy{Name: "default", Limit: 10, Period: time.Minute})
+	require.NoError(t, limiter.Route("POST /users", Policy{Limit: 2, Period: time.Minute}))
+	require.NoError(t, limiter.Route("GET /healthz", Policy{Disabled: true}))
+	assert.Error(t, limiter.Route("POST /users", Policy{Limit: 1, Period: time.Minute}), "Duplicate patterns should be rejected")
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	handler := httphelper.RequestID(limiter.Middleware(ok))
+	do := func(method, path, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/users", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", rec.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=60", rec.Header().Get("RateLimit-Policy"))
+
+	do(http.MethodPost, "/users", "10.0.0.1:1234")
+	rec = do(http.MethodPost, "/users", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "30", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/users", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-API-Key", "rotated-"+strconv.Itoa(i))
+		req.Header.Set(auth.SubjectHeader, "spoofed-"+strconv.Itoa(i))
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code, "Rotating client-set headers should not escape the limit")
+	}
+
+	rec = do(http.MethodPost, "/users", "10.0.0.2:1234")
+	assert.Equal(t, http.StatusNoContent, rec.Code, "Other clients should have their own bucket")
+
+	rec = do(http.MethodGet, "/users", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusNoContent, rec.Code, "Other routes should use the default policy")
+	assert.Equal(t, "10", rec.Header().Get("RateLimit-Limit"))
+
+	rec = do(http.MethodGet, "/healthz", "10.0.0.1:1234")
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Empty(t, rec.Header().Get("RateLimit-Limit"), "Disabled policies should not set headers")
+}
+
+func TestClientKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	var ipKey string
+	httphelper.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ipKey = ClientKey(r)
+		req = r
+	})).ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "ip:192.0.2.1", ipKey)
+
+	req.Header.Set("X-API-Key", "unverified")
+	assert.Equal(t, "ip:192.0.2.1", ClientKey(req), "Unverified API keys should not pick the bucket")
+
+	req = req.WithContext(auth.WithPrincipal(req.Context(), auth.Principal{Subject: "42"}))
+	assert.Equal(t, "user:42", ClientKey(req))
+}
@@ -0,0 +1,56 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+)
+
+// takeQuery refills and takes from a bucket in one atomic statement. $2 is
+// the limit and $3 the refill rate per second.
+const takeQuery = `
+	INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+	VALUES ($1, $2::float8 - 1, TRUE, NOW())
+	ON CONFLICT (key) DO UPDATE SET
+		tokens = CASE
+			WHEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8) >= 1
+			THEN LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8) - 1
+			ELSE LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8)
+		END,
+		allowed = LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM NOW() - b.updated_at) * $3::float8) >= 1,
+		updated_at = NOW()
+	RETURNING tokens, allowed`
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so that every
+// instance shares them. Each request costs one round trip.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStore(database *sql.DB) *PostgresStore {
+	return &PostgresStore{db: database}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, p Policy) (Result, error) {
+	var tokens float64
+	var allowed bool
+	if err := s.db.QueryRowContext(ctx, takeQuery, key, p.Limit, p.rate()).Scan(&tokens, &allowed); err != nil {
+		return Result{}, err
+	}
+	return result(p, tokens, allowed), nil
+}
+
+// PurgeIdle deletes buckets untouched for longer than olderThan. Make it
+// longer than the longest policy period: by then every bucket is full again.
+func PurgeIdle(ctx context.Context, q db.DBTX, olderThan time.Duration) (int64, error) {
+	result, err := q.ExecContext(ctx, `
+		DELETE FROM rate_limit_buckets
+		WHERE updated_at < NOW() - MAKE_INTERVAL(secs => $1)
+	`, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,43 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStore(t *testing.T) {
+	conn, _ := db.Connect()
+	ctx := context.Background()
+	store := NewPostgresStore(conn)
+	key := fmt.Sprintf("test:%d", time.Now().UnixNano())
+	p := Policy{Name: "test", Limit: 2, Period: time.Hour}
+
+	res, err := store.Take(ctx, key, p)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 1, res.Remaining)
+
+	res, _ = store.Take(ctx, key, p)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res, _ = store.Take(ctx, key, p)
+	assert.False(t, res.Allowed, "Bucket should be empty after the burst")
+	assert.Positive(t, res.RetryAfter)
+
+	_, err = conn.Exec("UPDATE rate_limit_buckets SET updated_at = NOW() - INTERVAL '2 days' WHERE key = $1", key)
+	require.NoError(t, err)
+	purged, err := PurgeIdle(ctx, conn, 24*time.Hour)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, purged, int64(1))
+
+	res, _ = store.Take(ctx, key, p)
+	assert.Equal(t, 1, res.Remaining, "Purged buckets should start full")
+}
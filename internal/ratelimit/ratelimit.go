@@ -0,0 +1,91 @@
+// Package ratelimit throttles clients with token buckets. Each client gets one
+// bucket per policy; routes can have stricter policies than the default. The
+// buckets live in a Store: in memory for a single instance or in Postgres when
+// several instances must share them.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy allows Limit requests per Period, refilled continuously, with bursts
+// of up to Limit requests. A disabled policy does not limit at all.
+type Policy struct {
+	Name     string
+	Limit    int
+	Period   time.Duration
+	Disabled bool
+}
+
+var periodUnits = map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+
+// ParsePolicy parses "<limit>/<period>" where period is s, m, h, d or a Go
+// duration such as 30s, e.g. "100/m". "off" disables limiting.
+func ParsePolicy(name, spec string) (Policy, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.EqualFold(spec, "off") {
+		return Policy{Name: name, Disabled: true}, nil
+	}
+	limitPart, periodPart, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("rate limit %q: expected <limit>/<period>", spec)
+	}
+	limit, err := strconv.Atoi(strings.TrimSpace(limitPart))
+	if err != nil || limit <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q: invalid limit", spec)
+	}
+	periodPart = strings.TrimSpace(periodPart)
+	period, ok := periodUnits[periodPart]
+	if !ok {
+		if period, err = time.ParseDuration(periodPart); err != nil || period <= 0 {
+			return Policy{}, fmt.Errorf("rate limit %q: invalid period", spec)
+		}
+	}
+	return Policy{Name: name, Limit: limit, Period: period}, nil
+}
+
+// rate is the refill rate in tokens per second.
+func (p Policy) rate() float64 {
+	return float64(p.Limit) / p.Period.Seconds()
+}
+
+// Result is the outcome of taking a token.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token, when not allowed.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// result describes a bucket left with tokens after a request.
+func result(p Policy, tokens float64, allowed bool) Result {
+	r := Result{
+		Allowed:   allowed,
+		Limit:     p.Limit,
+		Remaining: max(int(math.Floor(tokens)), 0),
+		Reset:     seconds((float64(p.Limit) - tokens) / p.rate()),
+	}
+	if !allowed {
+		r.RetryAfter = seconds((1 - tokens) / p.rate())
+	}
+	return r
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(max(s, 0) * float64(time.Second))
+}
+
+// Store keeps token buckets.
+type Store interface {
+	// Take refills the bucket key according to p and removes one token if
+	// there is one.
+	Take(ctx context.Context, key string, p Policy) (Result, error)
+}
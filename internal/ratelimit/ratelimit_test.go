@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy("default", "100/m")
+	require.NoError(t, err)
+	assert.Equal(t, Policy{Name: "default", Limit: 100, Period: time.Minute}, p)
+
+	p, err = ParsePolicy("burst", " 5 / 30s ")
+	require.NoError(t, err)
+	assert.Equal(t, 5, p.Limit)
+	assert.Equal(t, 30*time.Second, p.Period)
+
+	p, err = ParsePolicy("probes", "OFF")
+	require.NoError(t, err)
+	assert.True(t, p.Disabled)
+
+	for _, spec := range []string{"", "100", "0/m", "-1/m", "x/m", "10/w", "10/-5s"} {
+		_, err := ParsePolicy("bad", spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+	p := Policy{Name: "test", Limit: 3, Period: 3 * time.Second}
+
+	for i := 2; i >= 0; i-- {
+		res, err := store.Take(ctx, "a", p)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, i, res.Remaining)
+	}
+
+	res, _ := store.Take(ctx, "a", p)
+	assert.False(t, res.Allowed, "Bucket should be empty after the burst")
+	assert.Equal(t, time.Second, res.RetryAfter)
+	assert.Equal(t, 3*time.Second, res.Reset)
+
+	other, _ := store.Take(ctx, "b", p)
+	assert.True(t, other.Allowed, "Buckets should be per key")
+
+	now = now.Add(time.Second)
+	res, _ = store.Take(ctx, "a", p)
+	assert.True(t, res.Allowed, "One token should have been refilled")
+	assert.Equal(t, 0, res.Remaining)
+
+	now = now.Add(time.Hour)
+	res, _ = store.Take(ctx, "a", p)
+	assert.Equal(t, 2, res.Remaining, "Refill should stop at the limit")
+}
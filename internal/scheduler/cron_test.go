@@ -0,0 +1,64 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2024, time.January, 31, 10, 7, 30, 0, time.UTC) // a Wednesday
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2024, 1, 31, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"5 * * * *", time.Date(2024, 1, 31, 11, 5, 0, 0, time.UTC)},
+		{"30 3 * * *", time.Date(2024, 2, 1, 3, 30, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", time.Date(2024, 2, 4, 9, 0, 0, 0, time.UTC)},
+		{"0 12 1,15 * *", time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jun *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"10/20 * * * *", time.Date(2024, 1, 31, 10, 10, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		if assert.NoError(t, err, tt.expr) {
+			assert.Equal(t, tt.want, s.Next(from), tt.expr)
+		}
+	}
+}
+
+func TestScheduleNextKeepsLocation(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	s, _ := Parse("0 2 * * *")
+	next := s.Next(time.Date(2024, 7, 1, 12, 0, 0, 0, berlin))
+	assert.Equal(t, time.Date(2024, 7, 2, 2, 0, 0, 0, berlin), next)
+	assert.Equal(t, berlin, next.Location())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *",
+		"* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@often"} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestAddRejectsSchedulesThatNeverRun(t *testing.T) {
+	s := New(time.UTC)
+	assert.Error(t, s.Add(Task{Name: "never", Schedule: "0 0 30 2 *"}))
+	assert.NoError(t, s.Add(Task{Name: "daily", Schedule: "@daily"}))
+	assert.Error(t, s.Add(Task{Name: "daily", Schedule: "@hourly"}), "Names must be unique")
+}
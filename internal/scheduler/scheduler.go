@@ -0,0 +1,192 @@
+// Package scheduler runs recurring maintenance tasks on cron schedules. Every
+// API instance runs the scheduler, but a Postgres advisory lock and the
+// schedule state kept in scheduled_tasks make sure each due run happens on
+// one instance only.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+)
+
+// MissedRunPolicy decides what happens to runs missed while no instance was up.
+type MissedRunPolicy string
+
+const (
+	// RunOnce runs a task once to catch up, however many runs were missed.
+	RunOnce MissedRunPolicy = "run_once"
+	// Skip drops missed runs and waits for the next scheduled time.
+	Skip MissedRunPolicy = "skip"
+)
+
+const (
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+)
+
+// Task is a recurring job.
+type Task struct {
+	Name     string
+	Schedule string
+	Missed   MissedRunPolicy
+	Run      func(ctx context.Context) error
+}
+
+type task struct {
+	Task
+	schedule *Schedule
+}
+
+// retryDelay is how long a task waits after the database could not be reached.
+const retryDelay = 30 * time.Second
+
+// Scheduler runs registered tasks until its context is done.
+type Scheduler struct {
+	loc   *time.Location
+	tasks []*task
+}
+
+// New returns a Scheduler evaluating cron expressions in loc.
+func New(loc *time.Location) *Scheduler {
+	return &Scheduler{loc: loc}
+}
+
+// Add registers t. Register every task before Run.
+func (s *Scheduler) Add(t Task) error {
+	schedule, err := Parse(t.Schedule)
+	if err != nil {
+		return err
+	}
+	if schedule.Next(time.Now().In(s.loc)).IsZero() {
+		return fmt.Errorf("scheduler: task %q never runs on %q", t.Name, t.Schedule)
+	}
+	if t.Missed == "" {
+		t.Missed = RunOnce
+	}
+	for _, existing := range s.tasks {
+		if existing.Name == t.Name {
+			return fmt.Errorf("scheduler: task %q registered twice", t.Name)
+		}
+	}
+	s.tasks = append(s.tasks, &task{Task: t, schedule: schedule})
+	return nil
+}
+
+// Run runs every task on its schedule until ctx is done and then waits for
+// running tasks to return.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, t := range s.tasks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.loop(ctx, t)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, t *task) {
+	// check the shared state right away; it knows when the task is due
+	next := time.Now()
+	for {
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		next = s.attempt(ctx, t)
+	}
+}
+
+const upsertQuery = `
+	INSERT INTO scheduled_tasks (name, schedule, next_run_at)
+	VALUES ($1, $2, $3)
+	ON CONFLICT (name) DO UPDATE SET
+		schedule = EXCLUDED.schedule,
+		next_run_at = CASE WHEN scheduled_tasks.schedule = EXCLUDED.schedule
+			THEN scheduled_tasks.next_run_at ELSE EXCLUDED.next_run_at END
+	RETURNING next_run_at`
+
+// attempt runs t if it is due and no other instance is running it, and
+// returns when to look at it again. The advisory lock is held by the
+// transaction, so it is released even if this instance dies mid-run.
+func (s *Scheduler) attempt(ctx context.Context, t *task) time.Time {
+	database, err := db.Connect()
+	if err != nil {
+		return time.Now().Add(retryDelay)
+	}
+
+	var next time.Time
+	txCtx := context.WithoutCancel(ctx)
+	err = db.WithTx(txCtx, database, func(tx db.DBTX) error {
+		now := time.Now().In(s.loc)
+		var locked bool
+		if err := tx.QueryRowContext(txCtx, "SELECT pg_try_advisory_xact_lock(hashtext($1))", "scheduler:"+t.Name).Scan(&locked); err != nil {
+			return err
+		}
+		if !locked {
+			// another instance is running it right now
+			next = t.schedule.Next(now)
+			return nil
+		}
+
+		// a changed schedule starts over from now
+		var due time.Time
+		if err := tx.QueryRowContext(txCtx, upsertQuery, t.Name, t.Schedule, t.schedule.Next(now)).Scan(&due); err != nil {
+			return err
+		}
+		if due.After(now) {
+			next = due
+			return nil
+		}
+
+		logger := slog.With("task", t.Name)
+		status, errText := StatusSucceeded, ""
+		start := time.Now()
+		if t.Missed == Skip && !t.schedule.Next(due.In(s.loc)).After(now) {
+			status = StatusSkipped
+			logger.Info("scheduled task missed runs, skipping", "due", due)
+		} else if err := run(ctx, t); err != nil {
+			status, errText = StatusFailed, err.Error()
+			logger.Error("scheduled task failed", "error", err)
+		} else {
+			logger.Debug("scheduled task succeeded", "duration_ms", time.Since(start).Milliseconds())
+		}
+
+		next = t.schedule.Next(time.Now().In(s.loc))
+		_, err := tx.ExecContext(txCtx, `
+			UPDATE scheduled_tasks SET
+				next_run_at = $2,
+				last_run_at = CASE WHEN $3 = 'skipped' THEN last_run_at ELSE $4 END,
+				last_status = $3,
+				last_error = NULLIF($5, ''),
+				last_duration_ms = CASE WHEN $3 = 'skipped' THEN last_duration_ms ELSE $6 END
+			WHERE name = $1
+		`, t.Name, next, status, start, errText, float64(time.Since(start).Microseconds())/1000)
+		return err
+	}, db.Attempts(1))
+	if err != nil {
+		slog.Error("scheduler", "task", t.Name, "error", err)
+		return time.Now().Add(retryDelay)
+	}
+	return next
+}
+
+// run calls the task, turning a panic into an error.
+func run(ctx context.Context, t *task) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("task panicked: %v", p)
+		}
+	}()
+	return t.Run(ctx)
+}
@@ -0,0 +1,82 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttemptRunsDueTasksOnce(t *testing.T) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("DELETE FROM scheduled_tasks")
+	ctx := context.Background()
+
+	var runs atomic.Int64
+	task := Task{Name: "count", Schedule: "@hourly", Run: func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	}}
+	a, b := New(time.UTC), New(time.UTC)
+	assert.NoError(t, a.Add(task))
+	assert.NoError(t, b.Add(task))
+
+	// the first look only records the next run
+	next := a.attempt(ctx, a.tasks[0])
+	assert.Equal(t, a.tasks[0].schedule.Next(time.Now().UTC()), next.UTC())
+	assert.Equal(t, int64(0), runs.Load())
+
+	_, _ = conn.Exec("UPDATE scheduled_tasks SET next_run_at = NOW() - INTERVAL '1 minute' WHERE name = 'count'")
+	a.attempt(ctx, a.tasks[0])
+	b.attempt(ctx, b.tasks[0])
+	assert.Equal(t, int64(1), runs.Load(), "Only one instance should run a due task")
+
+	status, err := a.Status(ctx, conn)
+	assert.NoError(t, err)
+	if assert.Len(t, status, 1) {
+		assert.Equal(t, StatusSucceeded, status[0].LastStatus)
+		assert.NotNil(t, status[0].LastRunAt)
+		assert.True(t, status[0].NextRunAt.After(time.Now()))
+	}
+}
+
+func TestAttemptMissedRunPolicies(t *testing.T) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("DELETE FROM scheduled_tasks")
+	ctx := context.Background()
+
+	var runs atomic.Int64
+	s := New(time.UTC)
+	for _, task := range []Task{
+		{Name: "skip", Schedule: "@hourly", Missed: Skip},
+		{Name: "catch-up", Schedule: "@hourly", Missed: RunOnce},
+	} {
+		task.Run = func(ctx context.Context) error {
+			runs.Add(1)
+			return errors.New("disk full")
+		}
+		assert.NoError(t, s.Add(task))
+		s.attempt(ctx, s.tasks[len(s.tasks)-1])
+	}
+
+	// as if every instance had been down for a day
+	_, _ = conn.Exec("UPDATE scheduled_tasks SET next_run_at = NOW() - INTERVAL '1 day'")
+	for _, task := range s.tasks {
+		s.attempt(ctx, task)
+	}
+	assert.Equal(t, int64(1), runs.Load(), "Only the RunOnce task should catch up")
+
+	status, err := s.Status(ctx, conn)
+	assert.NoError(t, err)
+	if assert.Len(t, status, 2) {
+		assert.Equal(t, StatusSkipped, status[0].LastStatus)
+		assert.Nil(t, status[0].LastRunAt)
+		assert.Equal(t, StatusFailed, status[1].LastStatus)
+		assert.Equal(t, "disk full", status[1].LastError)
+	}
+}
@@ -0,0 +1,92 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/db"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+
+	"github.com/lib/pq"
+)
+
+// TaskStatus is the shared state of a task. Tasks that have not been picked up
+// by any instance yet show the locally computed next run.
+type TaskStatus struct {
+	Name           string          `json:"name"`
+	Schedule       string          `json:"schedule"`
+	Missed         MissedRunPolicy `json:"missed_run_policy"`
+	NextRunAt      time.Time       `json:"next_run_at"`
+	LastRunAt      *time.Time      `json:"last_run_at"`
+	LastStatus     string          `json:"last_status,omitempty"`
+	LastError      string          `json:"last_error,omitempty"`
+	LastDurationMS *float64        `json:"last_duration_ms,omitempty"`
+}
+
+// Status returns the state of every registered task in registration order.
+func (s *Scheduler) Status(ctx context.Context, q db.DBTX) ([]TaskStatus, error) {
+	names := make([]string, len(s.tasks))
+	for i, t := range s.tasks {
+		names[i] = t.Name
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT name, schedule, next_run_at, last_run_at, COALESCE(last_status, ''),
+			COALESCE(last_error, ''), last_duration_ms
+		FROM scheduled_tasks WHERE name = ANY($1)
+	`, pq.Array(names))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := map[string]TaskStatus{}
+	for rows.Next() {
+		var st TaskStatus
+		if err := rows.Scan(&st.Name, &st.Schedule, &st.NextRunAt, &st.LastRunAt, &st.LastStatus,
+			&st.LastError, &st.LastDurationMS); err != nil {
+			return nil, err
+		}
+		stored[st.Name] = st
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().In(s.loc)
+	out := make([]TaskStatus, len(s.tasks))
+	for i, t := range s.tasks {
+		st, ok := stored[t.Name]
+		if !ok {
+			st = TaskStatus{Name: t.Name}
+		}
+		if !ok || st.Schedule != t.Schedule {
+			st.Schedule, st.NextRunAt = t.Schedule, t.schedule.Next(now)
+		}
+		st.Missed = t.Missed
+		out[i] = st
+	}
+	return out, nil
+}
+
+// ListTasks handles GET /scheduler/tasks: the schedule, last and next run and
+// last error of every task. Only admins may read it.
+func (s *Scheduler) ListTasks(w http.ResponseWriter, r *http.Request) {
+	if !auth.FromContext(r.Context()).HasRole(auth.RoleAdmin) {
+		httphelper.Error(w, http.StatusForbidden, "The scheduler status requires the admin role")
+		return
+	}
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	// replicas may lag behind the last run
+	tasks, err := s.Status(r.Context(), database)
+	if err != nil {
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch scheduled tasks")
+		return
+	}
+	httphelper.JSON(w, http.StatusOK, tasks)
+}
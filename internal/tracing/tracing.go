@@ -0,0 +1,158 @@
+// Package tracing wires up OpenTelemetry: the tracer provider and exporter,
+// W3C traceparent propagation, server spans per route and sanitized spans for
+// every SQL statement.
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/logging"
+
+	"github.com/XSAM/otelsql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "gonesoft/go-dev-portfolio"
+
+// Setup installs the global tracer provider and W3C propagator. The exporter
+// is chosen with OTEL_TRACES_EXPORTER: "otlp" (configured through the standard
+// OTEL_EXPORTER_OTLP_* variables), "stdout" for local runs, or "none" (default).
+// The returned function flushes and stops the provider.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := config.String("OTEL_TRACES_EXPORTER", "none"); name {
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "none":
+		return func(context.Context) error { return nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewProvider(sdktrace.NewBatchSpanProcessor(exporter))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// NewProvider returns a tracer provider for this service feeding processor.
+// Tests pass a span recorder or an in-memory exporter wrapped in a syncer.
+func NewProvider(processor sdktrace.SpanProcessor) *sdktrace.TracerProvider {
+	res := resource.NewSchemaless(
+		semconv.ServiceName(config.String("OTEL_SERVICE_NAME", "craftfolio")),
+	)
+	ratio, err := strconv.ParseFloat(config.String("OTEL_TRACES_SAMPLER_ARG", "1"), 64)
+	if err != nil {
+		ratio = 1
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+}
+
+// Middleware continues the trace from the traceparent header (or starts one)
+// and wraps the request in a server span named after the route pattern. The
+// request logger gains trace and span IDs. Unknown methods are recorded as
+// OTHER so that clients cannot mint span names.
+func Middleware(next http.Handler) http.Handler {
+	tracer := otel.Tracer(instrumentationName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method := httphelper.Method(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		if sc := span.SpanContext(); sc.IsValid() {
+			ctx = logging.WithContext(ctx, logging.FromContext(ctx).With(
+				"trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String()))
+		}
+		r = r.WithContext(ctx)
+
+		sw := httphelper.WrapWriter(w)
+		next.ServeHTTP(sw, r)
+
+		if route := httphelper.Route(r); route != "" {
+			span.SetName(method + " " + route)
+			span.SetAttributes(semconv.HTTPRoute(route))
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(sw.Status))
+		if sw.Status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(sw.Status))
+		}
+	})
+}
+
+// SQLOptions configures otelsql so that every statement gets a client span
+// carrying the sanitized statement text instead of the raw query.
+func SQLOptions() []otelsql.Option {
+	return []otelsql.Option{
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableQuery:         true,
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+		otelsql.WithAttributesGetter(func(ctx context.Context, method otelsql.Method, query string, args []driver.NamedValue) []attribute.KeyValue {
+			if query == "" {
+				return nil
+			}
+			return []attribute.KeyValue{semconv.DBQueryText(SanitizeSQL(query))}
+		}),
+	}
+}
+
+var (
+	sqlStringLiteral  = regexp.MustCompile(`'(?:[^']|'')*'`)
+	sqlNumericLiteral = regexp.MustCompile(`\$\d+|\b\d+(?:\.\d+)?\b`)
+	sqlWhitespace     = regexp.MustCompile(`\s+`)
+)
+
+// SanitizeSQL collapses whitespace and replaces string and numeric literals
+// with "?" so that span attributes never carry user data. Placeholders such
+// as $1 are kept.
+func SanitizeSQL(query string) string {
+	query = sqlStringLiteral.ReplaceAllString(query, "?")
+	query = sqlNumericLiteral.ReplaceAllStringFunc(query, func(s string) string {
+		if strings.HasPrefix(s, "$") {
+			return s
+		}
+		return "?"
+	})
+	return strings.TrimSpace(sqlWhitespace.ReplaceAllString(query, " "))
+}
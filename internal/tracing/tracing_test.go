@@ -0,0 +1,75 @@
+package tracing
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	got := SanitizeSQL(`
+		SELECT id, name FROM users
+		WHERE email = 'jane@example.com' AND id > 42
+		  AND (LOWER(name) LIKE $1 OR version = $12)
+		LIMIT 10`)
+	assert.Equal(t, "SELECT id, name FROM users WHERE email = ? AND id > ? AND (LOWER(name) LIKE $1 OR version = $12) LIMIT ?", got)
+
+	assert.Equal(t, "SELECT ? FROM users_export2", SanitizeSQL("SELECT 'it''s' FROM users_export2"))
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := NewProvider(recorder)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer provider.Shutdown(t.Context())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
+		_, child := otel.Tracer("test").Start(r.Context(), "SELECT users")
+		child.End()
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Middleware(mux).ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	child, server := spans[0], spans[1]
+
+	assert.Equal(t, "GET /users/", server.Name())
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String(), "Server span should continue the incoming trace")
+	assert.Equal(t, server.SpanContext().SpanID(), child.Parent().SpanID(), "Handler spans should be children of the server span")
+	assert.Equal(t, "Error", server.Status().Code.String())
+}
+
+func TestMiddlewareCollapsesUnknownMethods(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := NewProvider(recorder)
+	otel.SetTracerProvider(provider)
+	defer provider.Shutdown(t.Context())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {})
+	Middleware(mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("FOO123", "/users/7", nil))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 1)
+	assert.Equal(t, "OTHER /users/", spans[0].Name())
+	for _, attr := range spans[0].Attributes() {
+		if attr.Key == semconv.HTTPRequestMethodKey {
+			assert.Equal(t, "OTHER", attr.Value.AsString())
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package users
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/cache"
+	"gonesoft/go-dev-portfolio/internal/changefeed"
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/logging"
+)
+
+var userCache atomic.Pointer[cache.Cache[User]]
+
+// UseCache makes LookupUser read through c; nil turns caching off.
+func UseCache(c *cache.Cache[User]) {
+	userCache.Store(c)
+}
+
+func userKey(id int) string {
+	return "user:" + strconv.Itoa(id)
+}
+
+// LookupUser is GetUserByIDFromDB behind the user cache. Requests that must
+// see their own writes (db.WithPrimary) skip the cache. Misses are filled from
+// the primary: a lagging replica could put a row back that was just
+// invalidated and serve it until CACHE_TTL. While the primary is unavailable
+// q answers, uncached.
+func LookupUser(ctx context.Context, q db.DBTX, id int) (User, error) {
+	c := userCache.Load()
+	if c == nil || db.UsePrimary(ctx) || id <= 0 {
+		return GetUserByIDFromDB(ctx, q, id)
+	}
+	primary, err := db.Connect()
+	if err != nil {
+		return GetUserByIDFromDB(ctx, q, id)
+	}
+	user, err := c.Get(ctx, userKey(id), func(ctx context.Context) (User, error) {
+		return GetUserByIDFromDB(ctx, primary, id)
+	})
+	if err != nil && db.Unavailable(err) {
+		return GetUserByIDFromDB(ctx, q, id)
+	}
+	return user, err
+}
+
+// invalidateUsers drops the cached copies of users this instance changed, so
+// it does not serve them until the change feed reports the change.
+func invalidateUsers(ctx context.Context, ids ...int) {
+	c := userCache.Load()
+	if c == nil || len(ids) == 0 {
+		return
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = userKey(id)
+	}
+	if err := c.Delete(ctx, keys...); err != nil {
+		logging.FromContext(ctx).Warn("invalidate cached users", "user_ids", ids, "error", err)
+	}
+}
+
+// InvalidateOnChanges drops cached users as the change feed reports changes to
+// them, whichever instance made them, until ctx is done. If the hub drops the
+// subscription the whole cache is cleared, since changes may have been missed.
+func InvalidateOnChanges(ctx context.Context, hub *changefeed.Hub) {
+	for {
+		changes, unsubscribe := hub.Subscribe()
+		for open := true; open; {
+			select {
+			case <-ctx.Done():
+				unsubscribe()
+				return
+			case c, ok := <-changes:
+				if !ok {
+					open = false
+					break
+				}
+				invalidateUsers(ctx, c.UserID)
+			}
+		}
+		if c := userCache.Load(); c != nil {
+			if err := c.Clear(ctx); err != nil {
+				logging.FromContext(ctx).Warn("clear user cache", "error", err)
+			}
+		}
+		// a closed hub hands out closed subscriptions; do not spin on them
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/emailaddr"
+	"gonesoft/go-dev-portfolio/internal/logging"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+	"gonesoft/go-dev-portfolio/internal/validate"
+
+	"github.com/lib/pq"
+	"golang.org/x/text/unicode/norm"
+)
+
+// canonicalEmail returns the form of email stored in users.email_canonical.
+// EMAIL_PROVIDER_RULES turns on provider aliasing such as Gmail's dots and
+// +tags; run RecanonicalizeEmails after changing it.
+func canonicalEmail(email string) (string, error) {
+	canonical, err := emailaddr.Canonical(email, emailaddr.Options{
+		ProviderRules: config.Bool("EMAIL_PROVIDER_RULES", false),
+	})
+	if err != nil {
+		return "", validate.Errors{}.Add("email", validate.ErrEmail.Error())
+	}
+	return canonical, nil
+}
+
+// emailSearch returns the LIKE pattern a search term is matched with against
+// email_canonical. A term that reads as an address is canonicalized first, so
+// "a.lovelace+x@gmail.com" finds adalovelace@gmail.com when provider rules are
+// on; anything else is lower-cased like the local part of a canonical address.
+func emailSearch(term string) string {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return "%"
+	}
+	if canonical, err := canonicalEmail(term); err == nil {
+		return "%" + canonical + "%"
+	}
+	return "%" + strings.ToLower(norm.NFC.String(term)) + "%"
+}
+
+// recanonicalizeBatch is the number of users read per round trip.
+const recanonicalizeBatch = 500
+
+// RecanonicalizeEmails recomputes email_canonical of every user, deleted ones
+// included, and returns the number of rows it changed. Rows migrated from
+// before the column, or written under other EMAIL_PROVIDER_RULES, get their
+// current canonical form. A row whose new form is taken by another live user
+// keeps the old one and is logged.
+func RecanonicalizeEmails(ctx context.Context, q db.DBTX) (int64, error) {
+	defer metrics.ObserveQuery("recanonicalize_emails", time.Now())
+	logger := logging.FromContext(ctx)
+	var changed int64
+	for after := 0; ; {
+		rows, err := q.QueryContext(ctx, `
+			SELECT id, email, email_canonical FROM users
+			WHERE id > $1
+			ORDER BY id
+			LIMIT $2
+		`, after, recanonicalizeBatch)
+		if err != nil {
+			return changed, err
+		}
+		type stale struct {
+			id        int
+			canonical string
+		}
+		var updates []stale
+		n := 0
+		for rows.Next() {
+			var id int
+			var email, stored string
+			if err := rows.Scan(&id, &email, &stored); err != nil {
+				rows.Close()
+				return changed, err
+			}
+			n++
+			after = id
+			canonical, err := canonicalEmail(email)
+			if err != nil {
+				logger.Warn("cannot canonicalize stored email", "user_id", id)
+				continue
+			}
+			if canonical != stored {
+				updates = append(updates, stale{id, canonical})
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return changed, err
+		}
+
+		// one statement per row, so a conflict only skips that row
+		for _, u := range updates {
+			_, err := q.ExecContext(ctx, `UPDATE users SET email_canonical = $1 WHERE id = $2`, u.canonical, u.id)
+			var pqErr *pq.Error
+			if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+				logger.Warn("canonical email already taken, keeping the old one", "user_id", u.id)
+				continue
+			}
+			if err != nil {
+				return changed, err
+			}
+			changed++
+		}
+		if n < recanonicalizeBatch {
+			return changed, nil
+		}
+	}
+}
@@ -1,14 +1,23 @@
 package users
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 
+	"gonesoft/go-dev-portfolio/internal/config"
 	"gonesoft/go-dev-portfolio/internal/db"
 	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/logging"
+	"gonesoft/go-dev-portfolio/internal/patch"
+	"gonesoft/go-dev-portfolio/internal/validate"
 )
 
 func UpdateUser(w http.ResponseWriter, r *http.Request) {
@@ -18,42 +27,182 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		httphelper.Error(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
+	versions, err := httphelper.IfMatchVersions(r)
+	if err != nil {
+		httphelper.PreconditionError(w, err)
+		return
+	}
 	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		httphelper.Error(w, http.StatusBadRequest, "Invalid request payload")
+	if err := httphelper.DecodeJSON(w, r, &user); err != nil {
+		httphelper.DecodeError(w, err)
 		return
 	}
-
-	if user.Name == "" || user.Email == "" {
-		httphelper.Error(w, http.StatusBadRequest, "Name and email are required")
+	if err := validate.Struct(&user); err != nil {
+		httphelper.ValidationError(w, http.StatusBadRequest, err)
 		return
 	}
 
-	database := db.Connect()
-	if database == nil {
-		httphelper.Error(w, http.StatusInternalServerError, "Failed to connect to the database")
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
 		return
 	}
 	//defer database.Close()
 
-	err = UpdateUserFromDB(database, id, &user)
+	err = UpdateUserFromDB(r.Context(), database, id, &user, versions)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			httphelper.Error(w, http.StatusNotFound, "User not found")
 			return
 		}
-		if strings.Contains(err.Error(), "exists") {
+		if err == ErrVersionMismatch {
+			httphelper.Error(w, http.StatusPreconditionFailed, "User was modified by another request")
+			return
+		}
+		if errors.Is(err, ErrEmailExists) {
 			httphelper.Error(w, http.StatusConflict, "Email already exists")
 			return
 		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
 		httphelper.Error(w, http.StatusInternalServerError, "Failed to update user")
 		return
 	}
 
+	w.Header().Set("ETag", httphelper.ETag(user.Version))
 	w.WriteHeader(http.StatusNoContent)
 
 }
 
+// PatchUser handles PATCH /users/{id} with a JSON Merge Patch (RFC 7396)
+// or JSON Patch (RFC 6902) body. Only the columns that change are written.
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
+	id, err := strconv.Atoi(idStr)
+	if err != nil || id <= 0 {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	versions, err := httphelper.IfMatchVersions(r)
+	if err != nil {
+		httphelper.PreconditionError(w, err)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != patch.MergePatchType && mediaType != patch.JSONPatchType {
+		w.Header().Set("Accept-Patch", patch.MergePatchType+", "+patch.JSONPatchType)
+		httphelper.Error(w, http.StatusUnsupportedMediaType, "Unsupported patch media type")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, httphelper.MaxBodyBytes()))
+	if err != nil {
+		httphelper.DecodeError(w, err)
+		return
+	}
+
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+
+	current, err := GetUserByIDFromDB(r.Context(), database, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			httphelper.Error(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch user")
+		return
+	}
+	if versions != nil && !slices.Contains(versions, current.Version) {
+		httphelper.Error(w, http.StatusPreconditionFailed, "User was modified by another request")
+		return
+	}
+
+	doc, _ := json.Marshal(current)
+	var patched []byte
+	if mediaType == patch.MergePatchType {
+		patched, err = patch.MergePatch(doc, body)
+	} else {
+		patched, err = patch.JSONPatch(doc, body)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, patch.ErrTestFailed):
+			httphelper.Error(w, http.StatusConflict, err.Error())
+		case errors.Is(err, patch.ErrPathNotFound):
+			httphelper.Error(w, http.StatusUnprocessableEntity, err.Error())
+		default:
+			httphelper.Error(w, http.StatusBadRequest, err.Error())
+		}
+		return
+	}
+
+	// the patched document must still be a valid user
+	var result User
+	dec := json.NewDecoder(bytes.NewReader(patched))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&result); err != nil {
+		httphelper.Error(w, http.StatusUnprocessableEntity, "Patched document is not a valid user")
+		return
+	}
+	if result.ID != current.ID || result.Version != current.Version {
+		httphelper.Error(w, http.StatusUnprocessableEntity, "id and version are read-only")
+		return
+	}
+	if err := validate.Struct(&result); err != nil {
+		httphelper.ValidationError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	var changes UserChanges
+	if result.Name != current.Name {
+		changes.Name = &result.Name
+	}
+	if result.Email != current.Email {
+		changes.Email = &result.Email
+	}
+	if changes.Name == nil && changes.Email == nil {
+		w.Header().Set("ETag", httphelper.ETag(current.Version))
+		httphelper.JSON(w, http.StatusOK, current)
+		return
+	}
+
+	// the patch was computed against current, so it must still be the stored version
+	updated, err := PatchUserInDB(r.Context(), database, id, changes, current.Version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			httphelper.Error(w, http.StatusNotFound, "User not found")
+			return
+		}
+		if err == ErrVersionMismatch {
+			httphelper.Error(w, http.StatusPreconditionFailed, "User was modified by another request")
+			return
+		}
+		if errors.Is(err, ErrEmailExists) {
+			httphelper.Error(w, http.StatusConflict, "Email already exists")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to update user")
+		return
+	}
+
+	w.Header().Set("ETag", httphelper.ETag(updated.Version))
+	httphelper.JSON(w, http.StatusOK, updated)
+}
+
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
 	id, err := strconv.Atoi(idStr)
@@ -61,19 +210,32 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 		httphelper.Error(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
-	database := db.Connect()
-	if database == nil {
-		httphelper.Error(w, http.StatusInternalServerError, "Failed to connect to the database")
+	versions, err := httphelper.IfMatchVersions(r)
+	if err != nil {
+		httphelper.PreconditionError(w, err)
+		return
+	}
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
 		return
 	}
 	//defer database.Close()
 
-	err = DeleteUserFromDB(database, id)
+	err = DeleteUserFromDB(r.Context(), database, id, versions)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			httphelper.Error(w, http.StatusNotFound, "User not found")
 			return
 		}
+		if err == ErrVersionMismatch {
+			httphelper.Error(w, http.StatusPreconditionFailed, "User was modified by another request")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
 		httphelper.Error(w, http.StatusInternalServerError, "Failed to delete user")
 		return
 	}
@@ -81,6 +243,51 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// RestoreUser handles POST /users/{id}/restore. If-Match is optional because a
+// deleted user cannot be fetched to learn its ETag.
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+	var versions []int
+	if r.Header.Get("If-Match") != "" {
+		versions, err = httphelper.IfMatchVersions(r)
+		if err != nil {
+			httphelper.PreconditionError(w, err)
+			return
+		}
+	}
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+
+	user, err := RestoreUserFromDB(r.Context(), database, id, versions)
+	if err != nil {
+		switch {
+		case err == sql.ErrNoRows:
+			httphelper.Error(w, http.StatusNotFound, "User not found")
+		case err == ErrNotDeleted:
+			httphelper.Error(w, http.StatusConflict, "User is not deleted")
+		case err == ErrVersionMismatch:
+			httphelper.Error(w, http.StatusPreconditionFailed, "User was modified by another request")
+		case errors.Is(err, ErrEmailExists):
+			httphelper.Error(w, http.StatusConflict, err.Error())
+		case db.Unavailable(err):
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+		default:
+			httphelper.Error(w, http.StatusInternalServerError, "Failed to restore user")
+		}
+		return
+	}
+
+	w.Header().Set("ETag", httphelper.ETag(user.Version))
+	httphelper.JSON(w, http.StatusOK, user)
+}
+
 func GetUserByID(w http.ResponseWriter, r *http.Request) {
 	idStr := strings.TrimPrefix(r.URL.Path, "/users/")
 	id, err := strconv.Atoi(idStr)
@@ -88,17 +295,29 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 		httphelper.Error(w, http.StatusBadRequest, "Invalid user ID")
 		return
 	}
-	database := db.Connect()
-	if database == nil {
-		httphelper.Error(w, http.StatusInternalServerError, "Failed to connect to the database")
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
 		return
 	}
-	//defer database.Close()
 
-	var user User
-	user, err = GetUserByIDFromDB(database, id)
+	user, err := LookupUser(r.Context(), reader, id)
 	if err != nil {
-		httphelper.Error(w, http.StatusNotFound, "User not found")
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			httphelper.Error(w, http.StatusNotFound, "User not found")
+		case db.Unavailable(err):
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+		default:
+			httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return
+	}
+
+	etag := httphelper.ETag(user.Version)
+	w.Header().Set("ETag", etag)
+	if httphelper.NoneMatch(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
 		return
 	}
 
@@ -109,31 +328,44 @@ func GetUserByID(w http.ResponseWriter, r *http.Request) {
 
 func CreateUser(w http.ResponseWriter, r *http.Request) {
 	var user User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		httphelper.Error(w, http.StatusBadRequest, "Invalid request payload")
+	if err := httphelper.DecodeJSON(w, r, &user); err != nil {
+		httphelper.DecodeError(w, err)
 		return
 	}
-
-	if user.Name == "" || user.Email == "" {
-		httphelper.Error(w, http.StatusBadRequest, "Name and email are required")
+	if err := validate.Struct(&user); err != nil {
+		httphelper.ValidationError(w, http.StatusBadRequest, err)
 		return
 	}
 
 	// Connect to the database
-	database := db.Connect()
-	if database == nil {
-		httphelper.Error(w, http.StatusInternalServerError, "Failed to connect to the database")
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
 		return
 	}
 	//defer database.Close()
 
-	err := CreateUserInDB(database, &user)
+	err = CreateUserInDB(r.Context(), database, &user)
 	if err != nil {
+		var invalid validate.Errors
+		if errors.As(err, &invalid) {
+			httphelper.ValidationError(w, http.StatusBadRequest, err)
+			return
+		}
+		if errors.Is(err, ErrEmailExists) {
+			httphelper.Error(w, http.StatusConflict, "Email already exists")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
 		httphelper.Error(w, http.StatusInternalServerError, "Failed to create user: "+err.Error())
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", httphelper.ETag(user.Version))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
 }
@@ -152,19 +384,12 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 	}
 	offset := (page - 1) * limit
 
-	//searching
-	searchTerm := r.URL.Query().Get("search")
-	if searchTerm != "" {
-		// Sanitize search term to prevent SQL injection
-		searchTerm = strings.TrimSpace(searchTerm)
-		searchTerm = "%" + strings.ToLower(searchTerm) + "%"
-	} else {
-		searchTerm = "%"
-	}
+	//searching; GetUsersFromDB builds the LIKE pattern
+	searchTerm := strings.TrimSpace(r.URL.Query().Get("search"))
 
 	// Sorting. If no sort parameter is provided, default to sorting by name
-	sortBy := r.URL.Query().Get("sort")
-	if sortBy == "" && sortBy != "email" && sortBy != "created_at" {
+	sortBy := strings.ToLower(r.URL.Query().Get("sort"))
+	if sortBy == "" {
 		sortBy = "name"
 	}
 
@@ -174,16 +399,25 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 		order = "ASC"
 	}
 
+	// the column name ends up in the query, so only the documented ones pass
+	if _, _, _, err := listFilter(ListOptions{SortBy: sortBy, Order: order}); err != nil {
+		httphelper.Error(w, http.StatusBadRequest, "invalid sort: allowed id,name,email,created_at")
+		return
+	}
+
 	// Connect to the database
-	database := db.Connect()
-	if database == nil {
-		httphelper.Error(w, http.StatusInternalServerError, "Failed to connect to the database")
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
 		return
 	}
-	//defer database.Close()
 
-	usersList, total, err := GetUsersFromDB(database, searchTerm, limit, offset, sortBy, order)
+	usersList, total, err := GetUsersFromDB(r.Context(), reader, searchTerm, limit, offset, sortBy, order)
 	if err != nil {
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
 		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch users: "+err.Error())
 		return
 	}
@@ -199,7 +433,11 @@ func GetUsers(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetUsersNoPaging(w http.ResponseWriter, r *http.Request) {
-	db := db.Connect()
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
 
 	// Parse query params
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
@@ -216,15 +454,18 @@ func GetUsersNoPaging(w http.ResponseWriter, r *http.Request) {
 		Order:  order,
 	}
 
-	list, total, err := ListUsers(db, opts)
+	list, total, err := ListUsers(r.Context(), reader, opts)
 	if err != nil {
-		switch err {
-		case ErrInvalidSort:
+		switch {
+		case err == ErrInvalidSort:
 			http.Error(w, "invalid sort: allowed id,name,email,created_at", http.StatusBadRequest)
 			return
-		case ErrInvalidOrder:
+		case err == ErrInvalidOrder:
 			http.Error(w, "invalid order: allowed ASC,DESC", http.StatusBadRequest)
 			return
+		case db.Unavailable(err):
+			http.Error(w, "database unavailable", http.StatusServiceUnavailable)
+			return
 		default:
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
@@ -248,3 +489,151 @@ func GetUsersNoPaging(w http.ResponseWriter, r *http.Request) {
 		"users":       list,
 	})
 }
+
+// BatchUsers handles POST /users:batch. Each operation gets its own status;
+// atomic mode (the default) commits all of them or none.
+func BatchUsers(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := httphelper.DecodeJSON(w, r, &req); err != nil {
+		httphelper.DecodeError(w, err)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = BatchModeAtomic
+	}
+	if req.Mode != BatchModeAtomic && req.Mode != BatchModeBestEffort {
+		httphelper.Error(w, http.StatusBadRequest, "invalid mode: allowed atomic,best_effort")
+		return
+	}
+	if len(req.Operations) == 0 {
+		httphelper.Error(w, http.StatusBadRequest, "operations must not be empty")
+		return
+	}
+	maxSize := config.Int("USERS_BATCH_MAX_SIZE", 500)
+	if len(req.Operations) > maxSize {
+		httphelper.Error(w, http.StatusRequestEntityTooLarge, "batch exceeds "+strconv.Itoa(maxSize)+" operations")
+		return
+	}
+
+	results := make([]BatchResult, len(req.Operations))
+	var valid []BatchOperation
+	var positions []int
+	invalid := 0
+	for i := range req.Operations {
+		op := &req.Operations[i]
+		results[i] = BatchResult{Index: i, Op: op.Op}
+		if err := validateBatchOperation(op); err != nil {
+			results[i].Status, results[i].Error = http.StatusBadRequest, "Validation failed"
+			var fields validate.Errors
+			if errors.As(err, &fields) {
+				results[i].Fields = fields
+			}
+			invalid++
+			continue
+		}
+		valid = append(valid, *op)
+		positions = append(positions, i)
+	}
+
+	atomic := req.Mode == BatchModeAtomic
+	if atomic && invalid > 0 {
+		for i := range results {
+			if results[i].Status == 0 {
+				results[i].Status, results[i].Error = http.StatusFailedDependency, ErrBatchAborted.Error()
+			}
+		}
+		httphelper.JSON(w, http.StatusBadRequest, map[string]interface{}{"mode": req.Mode, "results": results})
+		return
+	}
+
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+
+	items, err := ExecuteBatch(r.Context(), database, valid, atomic)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("batch failed", "operations", len(valid), "atomic", atomic, "error", err)
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to run batch")
+		return
+	}
+
+	status := http.StatusOK
+	failed := invalid
+	for k, item := range items {
+		result := &results[positions[k]]
+		result.Status, result.Error = batchStatus(result.Op, item.Err)
+		result.User = item.User
+		if item.Err == nil {
+			continue
+		}
+		failed++
+		// an atomic batch answers with the status of the item that failed it
+		if atomic && item.Err != ErrBatchAborted {
+			status = result.Status
+		}
+	}
+	if !atomic && failed > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	httphelper.JSON(w, status, map[string]interface{}{
+		"mode":      req.Mode,
+		"succeeded": len(results) - failed,
+		"failed":    failed,
+		"results":   results,
+	})
+}
+
+// validateBatchOperation checks the declared rules of op and which fields its
+// kind needs: create takes name and email, update also id and version, and
+// delete only id and version.
+func validateBatchOperation(op *BatchOperation) error {
+	var errs validate.Errors
+	if err := validate.Struct(op); err != nil && !errors.As(err, &errs) {
+		return err
+	}
+	need := func(field string, missing bool) {
+		if missing {
+			errs = errs.Add(field, "is required")
+		}
+	}
+	if op.Op == BatchUpdate || op.Op == BatchDelete {
+		need("id", op.ID == 0)
+		need("version", op.Version == 0)
+	}
+	if op.Op == BatchCreate || op.Op == BatchUpdate {
+		need("name", op.Name == "")
+		need("email", op.Email == "")
+	}
+	return errs.Err()
+}
+
+// batchStatus maps the repository outcome of one batch operation to an HTTP status.
+func batchStatus(op string, err error) (int, string) {
+	switch {
+	case err == nil && op == BatchCreate:
+		return http.StatusCreated, ""
+	case err == nil && op == BatchDelete:
+		return http.StatusNoContent, ""
+	case err == nil:
+		return http.StatusOK, ""
+	case err == ErrBatchAborted:
+		return http.StatusFailedDependency, err.Error()
+	case err == sql.ErrNoRows:
+		return http.StatusNotFound, "User not found"
+	case err == ErrVersionMismatch:
+		return http.StatusPreconditionFailed, "User was modified by another request"
+	case errors.Is(err, ErrEmailExists):
+		return http.StatusConflict, "Email already exists"
+	case db.Unavailable(err):
+		return http.StatusServiceUnavailable, "Database unavailable"
+	default:
+		return http.StatusInternalServerError, err.Error()
+	}
+}
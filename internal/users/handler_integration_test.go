@@ -2,6 +2,8 @@ package users
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"gonesoft/go-dev-portfolio/internal/db"
 	"net/http"
 	"net/http/httptest"
@@ -9,11 +11,12 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestUserLifeCycle(t *testing.T) {
-	testDB := db.Connect()
+	testDB, _ := db.Connect()
 	_, _ = testDB.Exec("DELETE FROM users")
 
 	// Router wiring:
@@ -36,6 +39,8 @@ func TestUserLifeCycle(t *testing.T) {
 			GetUserByID(w, r)
 		case http.MethodPut:
 			UpdateUser(w, r)
+		case http.MethodPatch:
+			PatchUser(w, r)
 		case http.MethodDelete:
 			DeleteUser(w, r)
 		default:
@@ -47,6 +52,7 @@ func TestUserLifeCycle(t *testing.T) {
 	defer ts.Close()
 
 	var createdUser User
+	var etag string
 
 	// 1) Create a user
 	t.Run("Create User", func(t *testing.T) {
@@ -67,6 +73,32 @@ func TestUserLifeCycle(t *testing.T) {
 		resp, err := http.Get(ts.URL + "/users/" + strconv.Itoa(createdUser.ID))
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		etag = resp.Header.Get("ETag")
+		assert.NotEmpty(t, etag, "Expected an ETag header")
+		resp.Body.Close()
+	})
+
+	// 2a) Conditional GET with the current ETag -> 304
+	t.Run("Fetch User Not Modified", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/users/"+strconv.Itoa(createdUser.ID), nil)
+		req.Header.Set("If-None-Match", etag)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		resp.Body.Close()
+	})
+
+	// 2b) Update without If-Match -> 428
+	t.Run("Update User Without If-Match", func(t *testing.T) {
+		req, _ := http.NewRequest(
+			http.MethodPut,
+			ts.URL+"/users/"+strconv.Itoa(createdUser.ID),
+			strings.NewReader(`{"name":"Updated","email":"updated@example.com"}`),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPreconditionRequired, resp.StatusCode)
 		resp.Body.Close()
 	})
 
@@ -78,16 +110,103 @@ func TestUserLifeCycle(t *testing.T) {
 			strings.NewReader(`{"name":"Updated","email":"updated@example.com"}`),
 		)
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag)
 		updatedResp, err := http.DefaultClient.Do(req)
 		assert.NoError(t, err)
 		// Expect 204 No Content if your handler follows that convention
 		assert.Equal(t, http.StatusNoContent, updatedResp.StatusCode)
+		assert.NotEqual(t, etag, updatedResp.Header.Get("ETag"), "Update should change the ETag")
 		updatedResp.Body.Close()
 	})
 
+	// 3a) Update again with the stale ETag -> 412
+	t.Run("Update User With Stale ETag", func(t *testing.T) {
+		req, _ := http.NewRequest(
+			http.MethodPut,
+			ts.URL+"/users/"+strconv.Itoa(createdUser.ID),
+			strings.NewReader(`{"name":"Lost Update","email":"updated@example.com"}`),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+		resp.Body.Close()
+	})
+
+	// 3a) If-Match may list several ETags; any current one lets the write through
+	t.Run("Update User With ETag List", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/users/" + strconv.Itoa(createdUser.ID))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		current := resp.Header.Get("ETag")
+
+		req, _ := http.NewRequest(
+			http.MethodPut,
+			ts.URL+"/users/"+strconv.Itoa(createdUser.ID),
+			strings.NewReader(`{"name":"Updated","email":"updated@example.com"}`),
+		)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag+", "+current)
+		resp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		resp.Body.Close()
+	})
+
+	// 3b) Partial updates with merge patch and JSON patch
+	t.Run("Patch User", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/users/" + strconv.Itoa(createdUser.ID))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		etag = resp.Header.Get("ETag")
+
+		req, _ := http.NewRequest(http.MethodPatch, ts.URL+"/users/"+strconv.Itoa(createdUser.ID),
+			strings.NewReader(`{"name":"Merged"}`))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", etag)
+		resp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		var patched User
+		_ = json.NewDecoder(resp.Body).Decode(&patched)
+		resp.Body.Close()
+		assert.Equal(t, "Merged", patched.Name)
+		assert.Equal(t, "updated@example.com", patched.Email, "Merge patch should leave email untouched")
+		etag = resp.Header.Get("ETag")
+
+		req, _ = http.NewRequest(http.MethodPatch, ts.URL+"/users/"+strconv.Itoa(createdUser.ID),
+			strings.NewReader(`[{"op":"test","path":"/name","value":"Someone Else"},{"op":"replace","path":"/name","value":"Nope"}]`))
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		req.Header.Set("If-Match", etag)
+		resp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusConflict, resp.StatusCode, "Failed test op should return 409")
+		resp.Body.Close()
+
+		req, _ = http.NewRequest(http.MethodPatch, ts.URL+"/users/"+strconv.Itoa(createdUser.ID),
+			strings.NewReader(`[{"op":"test","path":"/name","value":"Merged"},{"op":"remove","path":"/email"}]`))
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		req.Header.Set("If-Match", etag)
+		resp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode, "Patched user without email is invalid")
+		resp.Body.Close()
+
+		req, _ = http.NewRequest(http.MethodPatch, ts.URL+"/users/"+strconv.Itoa(createdUser.ID),
+			strings.NewReader(`{"name":"Wrong Type"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag)
+		resp, err = http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnsupportedMediaType, resp.StatusCode)
+		resp.Body.Close()
+	})
+
 	// 4) Delete the user
 	t.Run("Delete User", func(t *testing.T) {
 		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/users/"+strconv.Itoa(createdUser.ID), nil)
+		req.Header.Set("If-Match", "*")
 		delResp, err := http.DefaultClient.Do(req)
 		assert.NoError(t, err)
 		assert.Equal(t, http.StatusNoContent, delResp.StatusCode)
@@ -112,6 +231,7 @@ func TestUserLifeCycle(t *testing.T) {
 		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/users/2",
 			strings.NewReader(`{"name":"User2 Updated","email":"user1@example.com"}`))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
 		resp, _ := http.DefaultClient.Do(req)
 		assert.Equal(t, http.StatusConflict, resp.StatusCode)
 	})
@@ -121,6 +241,7 @@ func TestUserLifeCycle(t *testing.T) {
 		req, _ := http.NewRequest(http.MethodPut, ts.URL+"/users/9999",
 			strings.NewReader(`{"name":"Doesn't exist","email":"nope@example.com"}`))
 		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", "*")
 		resp, _ := http.DefaultClient.Do(req)
 		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 	})
@@ -128,6 +249,7 @@ func TestUserLifeCycle(t *testing.T) {
 	// 8) Test delete non-existent user
 	t.Run("Delete non-existent user returns 404", func(t *testing.T) {
 		req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/users/9999", nil)
+		req.Header.Set("If-Match", "*")
 		resp, _ := http.DefaultClient.Do(req)
 		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
 	})
@@ -144,3 +266,84 @@ func TestUserLifeCycle(t *testing.T) {
 		assert.LessOrEqual(t, len(users), 5, "Expected no more than 5 users in the response")
 	})
 }
+
+func TestBatchUsers(t *testing.T) {
+	testDB, _ := db.Connect()
+	_, _ = testDB.Exec("DELETE FROM users")
+
+	ts := httptest.NewServer(http.HandlerFunc(BatchUsers))
+	defer ts.Close()
+
+	t.Run("All items succeed", func(t *testing.T) {
+		resp, err := http.Post(ts.URL+"/users:batch", "application/json", strings.NewReader(`{
+			"mode": "atomic",
+			"operations": [
+				{"op":"create","name":"Batch One","email":"batch1@example.com"},
+				{"op":"create","name":"Batch Two","email":"batch2@example.com"}
+			]}`))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body struct {
+			Succeeded int           `json:"succeeded"`
+			Results   []BatchResult `json:"results"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		assert.Equal(t, 2, body.Succeeded)
+		assert.Equal(t, http.StatusCreated, body.Results[1].Status)
+	})
+
+	t.Run("Best effort reports per-item failures", func(t *testing.T) {
+		resp, err := http.Post(ts.URL+"/users:batch", "application/json", strings.NewReader(`{
+			"mode": "best_effort",
+			"operations": [
+				{"op":"create","name":"Batch Three","email":"batch3@example.com"},
+				{"op":"create","name":"Dup","email":"batch1@example.com"},
+				{"op":"delete","id":999999,"version":1},
+				{"op":"explode"}
+			]}`))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+
+		var body struct {
+			Failed  int           `json:"failed"`
+			Results []BatchResult `json:"results"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		assert.Equal(t, 3, body.Failed)
+		assert.Equal(t, http.StatusCreated, body.Results[0].Status)
+		assert.Equal(t, http.StatusConflict, body.Results[1].Status)
+		assert.Equal(t, http.StatusNotFound, body.Results[2].Status)
+		assert.Equal(t, http.StatusBadRequest, body.Results[3].Status)
+	})
+
+	t.Run("Oversized batch is rejected", func(t *testing.T) {
+		t.Setenv("USERS_BATCH_MAX_SIZE", "1")
+		resp, err := http.Post(ts.URL+"/users:batch", "application/json", strings.NewReader(`{
+			"operations": [
+				{"op":"create","name":"A","email":"a@example.com"},
+				{"op":"create","name":"B","email":"b@example.com"}
+			]}`))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+}
+
+func TestBatchStatusEmailConflict(t *testing.T) {
+	status, _ := batchStatus(BatchUpdate, emailTaken(&pq.Error{Code: "23505", Table: "users"}, "a@example.com"))
+	assert.Equal(t, http.StatusConflict, status, "A unique violation on users should be a conflict")
+	status, _ = batchStatus(BatchUpdate, emailTaken(fmt.Errorf("update: %w", &pq.Error{Code: "23503", Table: "users"}), "a@example.com"))
+	assert.Equal(t, http.StatusInternalServerError, status, "Other database errors stay server errors")
+	status, _ = batchStatus(BatchCreate, fmt.Errorf("%w: a@example.com", ErrEmailExists))
+	assert.Equal(t, http.StatusConflict, status)
+	status, _ = batchStatus(BatchCreate, errors.New("relation already exists"))
+	assert.Equal(t, http.StatusInternalServerError, status, "Conflicts are not guessed from the message")
+}
+
+func TestBatchStatusUnavailable(t *testing.T) {
+	status, _ := batchStatus(BatchUpdate, fmt.Errorf("update: %w", db.ErrUnavailable))
+	assert.Equal(t, http.StatusServiceUnavailable, status, "An outage should not look like a server bug")
+}
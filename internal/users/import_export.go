@@ -0,0 +1,321 @@
+package users
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gonesoft/go-dev-portfolio/internal/config"
+	"gonesoft/go-dev-portfolio/internal/db"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+	"gonesoft/go-dev-portfolio/internal/logging"
+	"gonesoft/go-dev-portfolio/internal/validate"
+)
+
+const (
+	FormatCSV   = "csv"
+	FormatJSONL = "jsonl"
+)
+
+// ExportUsers handles GET /users/export?format=csv|jsonl. It honours the same
+// search, sort and order parameters as the list endpoint and streams the rows
+// as they are read.
+func ExportUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = FormatCSV
+	}
+	if format != FormatCSV && format != FormatJSONL {
+		httphelper.Error(w, http.StatusBadRequest, "invalid format: allowed csv,jsonl")
+		return
+	}
+
+	opts := ListOptions{
+		Search: q.Get("search"),
+		SortBy: q.Get("sort"),
+		Order:  q.Get("order"),
+	}
+	if _, _, _, err := listFilter(opts); err != nil {
+		switch err {
+		case ErrInvalidSort:
+			httphelper.Error(w, http.StatusBadRequest, "invalid sort: allowed id,name,email,created_at")
+		default:
+			httphelper.Error(w, http.StatusBadRequest, "invalid order: allowed ASC,DESC")
+		}
+		return
+	}
+
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+
+	// headers and the first bytes go out with the first row, so a query that
+	// fails before that still gets a proper error response
+	var write func(User) error
+	var flush func()
+	started := false
+	start := func() {
+		started = true
+		switch format {
+		case FormatCSV:
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", `attachment; filename="users.csv"`)
+			cw := csv.NewWriter(w)
+			_ = cw.Write([]string{"id", "name", "email", "version"})
+			write = func(u User) error {
+				return cw.Write([]string{strconv.Itoa(u.ID), u.Name, u.Email, strconv.Itoa(u.Version)})
+			}
+			flush = cw.Flush
+		default:
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="users.jsonl"`)
+			enc := json.NewEncoder(w)
+			write = func(u User) error { return enc.Encode(u) }
+			flush = func() {}
+		}
+	}
+
+	flusher, _ := w.(http.Flusher)
+	count := 0
+	err = ExportUsersFromDB(r.Context(), reader, opts, func(u User) error {
+		if !started {
+			start()
+		}
+		if err := write(u); err != nil {
+			return err
+		}
+		count++
+		if count%exportFetchSize == 0 {
+			flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	switch {
+	case err != nil && !started:
+		logging.FromContext(r.Context()).Error("export users failed", "format", format, "error", err)
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to export users")
+	case err != nil:
+		flush()
+		// the status line is gone already; the truncated body is all the client gets
+		logging.FromContext(r.Context()).Error("export users failed", "format", format, "rows", count, "error", err)
+	default:
+		// an empty export is still a file with its header
+		if !started {
+			start()
+		}
+		flush()
+	}
+}
+
+// ImportError reports why the row on Line was not imported.
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// ImportResult is the response body of POST /users/import.
+type ImportResult struct {
+	DryRun   bool          `json:"dry_run"`
+	Total    int           `json:"total"`
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// importRow is a parsed row together with the line it came from.
+type importRow struct {
+	Line int
+	User User
+}
+
+// ImportUsers handles POST /users/import. The body is CSV with a name,email
+// header or JSON Lines; the format comes from ?format= or the Content-Type.
+// Valid rows are imported and every rejected row is reported with its line
+// number. With ?dry_run=true nothing is written.
+func ImportUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/x-ndjson", "application/jsonl":
+			format = FormatJSONL
+		default:
+			format = FormatCSV
+		}
+	}
+	dryRun, _ := strconv.ParseBool(q.Get("dry_run"))
+
+	body := http.MaxBytesReader(w, r.Body, int64(config.Int("USERS_IMPORT_MAX_BYTES", 10<<20)))
+	var rows []importRow
+	var rowErrors []ImportError
+	var err error
+	switch format {
+	case FormatCSV:
+		rows, rowErrors, err = readImportCSV(body)
+	case FormatJSONL:
+		rows, rowErrors, err = readImportJSONL(body)
+	default:
+		httphelper.Error(w, http.StatusBadRequest, "invalid format: allowed csv,jsonl")
+		return
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		httphelper.Error(w, http.StatusRequestEntityTooLarge, "Import file exceeds "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes")
+		return
+	}
+	if err != nil {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid import file: "+err.Error())
+		return
+	}
+
+	// reject invalid rows and duplicates inside the file before touching the DB
+	seen := map[string]int{}
+	var valid []importRow
+	for _, row := range rows {
+		if err := validate.Struct(&row.User); err != nil {
+			rowErrors = append(rowErrors, ImportError{Line: row.Line, Error: err.Error()})
+			continue
+		}
+		key, _ := canonicalEmail(row.User.Email)
+		if first, ok := seen[key]; ok {
+			rowErrors = append(rowErrors, ImportError{Line: row.Line, Error: fmt.Sprintf("Duplicate of line %d", first)})
+			continue
+		}
+		seen[key] = row.Line
+		valid = append(valid, row)
+	}
+
+	imported := 0
+	if len(valid) > 0 {
+		database, err := db.Connect()
+		if err != nil {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+			return
+		}
+
+		users := make([]*User, len(valid))
+		for i := range valid {
+			users[i] = &valid[i].User
+		}
+		created, err := ImportUsersInDB(r.Context(), database, users, dryRun)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("import users failed", "format", format, "rows", len(users), "error", err)
+			if db.Unavailable(err) {
+				httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+				return
+			}
+			httphelper.Error(w, http.StatusInternalServerError, "Failed to import users")
+			return
+		}
+		for i, ok := range created {
+			if ok {
+				imported++
+				continue
+			}
+			rowErrors = append(rowErrors, ImportError{Line: valid[i].Line, Error: "Email already exists"})
+		}
+	}
+
+	sort.Slice(rowErrors, func(i, j int) bool { return rowErrors[i].Line < rowErrors[j].Line })
+	httphelper.JSON(w, http.StatusOK, ImportResult{
+		DryRun:   dryRun,
+		Total:    imported + len(rowErrors),
+		Imported: imported,
+		Failed:   len(rowErrors),
+		Errors:   rowErrors,
+	})
+}
+
+// readImportCSV parses a CSV file whose header names a name and an email column.
+// Rows that cannot be parsed are returned as errors; other columns are ignored.
+func readImportCSV(body io.Reader) ([]importRow, []ImportError, error) {
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("missing header row: %w", err)
+	}
+	nameCol, emailCol := -1, -1
+	for i, col := range header {
+		switch strings.ToLower(strings.TrimSpace(col)) {
+		case "name":
+			nameCol = i
+		case "email":
+			emailCol = i
+		}
+	}
+	if nameCol < 0 || emailCol < 0 {
+		return nil, nil, errors.New("header must contain name and email columns")
+	}
+
+	var rows []importRow
+	var rowErrors []ImportError
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, err
+			}
+			rowErrors = append(rowErrors, ImportError{Line: parseErr.Line, Error: parseErr.Err.Error()})
+			if errors.Is(parseErr.Err, csv.ErrFieldCount) {
+				continue
+			}
+			// the reader cannot resync after a quoting error
+			break
+		}
+		rows = append(rows, importRow{Line: line, User: User{Name: record[nameCol], Email: record[emailCol]}})
+	}
+	return rows, rowErrors, nil
+}
+
+// readImportJSONL parses one JSON user object per line. Blank lines are skipped.
+func readImportJSONL(body io.Reader) ([]importRow, []ImportError, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var rows []importRow
+	var rowErrors []ImportError
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var u User
+		if err := json.Unmarshal([]byte(text), &u); err != nil {
+			rowErrors = append(rowErrors, ImportError{Line: line, Error: "Invalid JSON: " + err.Error()})
+			continue
+		}
+		rows = append(rows, importRow{Line: line, User: User{Name: u.Name, Email: u.Email}})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return rows, rowErrors, nil
+}
@@ -0,0 +1,106 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadImportCSV(t *testing.T) {
+	rows, rowErrors, err := readImportCSV(strings.NewReader(
+		"email,name\n" +
+			"ann@example.com,Ann\n" +
+			"too,many,fields\n" +
+			"bo@example.com,Bo\n"))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+	assert.Equal(t, 2, rows[0].Line)
+	assert.Equal(t, "Ann", rows[0].User.Name)
+	assert.Equal(t, 4, rows[1].Line)
+	assert.Equal(t, []ImportError{{Line: 3, Error: "wrong number of fields"}}, rowErrors)
+
+	_, _, err = readImportCSV(strings.NewReader("first,last\nA,B\n"))
+	assert.Error(t, err, "Header without name and email should be rejected")
+}
+
+func TestExportAndImportUsers(t *testing.T) {
+	testDB, _ := db.Connect()
+	_, _ = testDB.Exec("DELETE FROM users")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/export", ExportUsers)
+	mux.HandleFunc("/users/import", ImportUsers)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	t.Run("Dry run reports errors without writing", func(t *testing.T) {
+		resp, err := http.Post(ts.URL+"/users/import?dry_run=true", "text/csv", strings.NewReader(
+			"name,email\nAnn,ann@import.com\n,missing@import.com\nAnn Again,ANN@import.com\n"))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var result ImportResult
+		_ = json.NewDecoder(resp.Body).Decode(&result)
+		assert.True(t, result.DryRun)
+		assert.Equal(t, 1, result.Imported)
+		assert.Equal(t, 2, result.Failed)
+		assert.Equal(t, 3, result.Errors[0].Line)
+		assert.Equal(t, 4, result.Errors[1].Line)
+
+		var count int
+		_ = testDB.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
+		assert.Equal(t, 0, count, "Dry run should not insert users")
+	})
+
+	t.Run("JSONL import then CSV export", func(t *testing.T) {
+		resp, err := http.Post(ts.URL+"/users/import", "application/x-ndjson", strings.NewReader(
+			`{"name":"Zed","email":"zed@import.com"}`+"\n"+
+				`{"name":"Amy","email":"amy@import.com"}`+"\n"+
+				`not json`+"\n"))
+		assert.NoError(t, err)
+		var result ImportResult
+		_ = json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		assert.Equal(t, 2, result.Imported)
+		if assert.Len(t, result.Errors, 1) {
+			assert.Equal(t, 3, result.Errors[0].Line)
+			assert.Contains(t, result.Errors[0].Error, "Invalid JSON")
+		}
+
+		resp, err = http.Get(ts.URL + "/users/export?format=csv&sort=name&order=asc&search=import")
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		assert.Equal(t, "id,name,email,version", lines[0])
+		assert.Len(t, lines, 3)
+		assert.Contains(t, lines[1], "Amy")
+		assert.Contains(t, lines[2], "Zed")
+	})
+
+	t.Run("Export that fails before the first row gets an error status", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		rec := httptest.NewRecorder()
+		ExportUsers(rec, httptest.NewRequest(http.MethodGet, "/users/export", nil).WithContext(ctx))
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"), "No CSV header should have been sent")
+		assert.NotContains(t, rec.Body.String(), "id,name,email,version")
+	})
+
+	t.Run("Invalid sort is rejected", func(t *testing.T) {
+		resp, err := http.Get(ts.URL + "/users/export?sort=password")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
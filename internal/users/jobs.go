@@ -0,0 +1,60 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/mail"
+)
+
+// WelcomeEmail sends the welcome email to a newly created user. It is
+// enqueued in the transaction that creates the user.
+type WelcomeEmail struct {
+	UserID int `json:"user_id"`
+}
+
+func (WelcomeEmail) Kind() string { return "users.welcome_email" }
+
+// SendWelcomeEmail returns the WelcomeEmail handler. Users deleted before the
+// job runs get no email.
+func SendWelcomeEmail(m mail.Mailer) func(ctx context.Context, job WelcomeEmail) error {
+	return func(ctx context.Context, job WelcomeEmail) error {
+		database, err := db.Connect()
+		if err != nil {
+			return err
+		}
+		user, err := GetUserByIDFromDB(ctx, database, job.UserID)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return m.Send(ctx, mail.Message{
+			To:      user.Email,
+			Subject: "Welcome to Craftfolio",
+			Body:    fmt.Sprintf("Hi %s,\n\nyour Craftfolio account is ready.\n", user.Name),
+		})
+	}
+}
+
+// PurgeDeleted permanently removes users soft-deleted more than OlderThan
+// ago. The scheduler enqueues it once a day.
+type PurgeDeleted struct {
+	OlderThan time.Duration `json:"older_than"`
+}
+
+func (PurgeDeleted) Kind() string { return "users.purge_deleted" }
+
+// RunPurgeDeleted is the PurgeDeleted handler.
+func RunPurgeDeleted(ctx context.Context, job PurgeDeleted) error {
+	database, err := db.Connect()
+	if err != nil {
+		return err
+	}
+	_, err = PurgeDeletedUsers(ctx, database, job.OlderThan)
+	return err
+}
@@ -1,7 +1,51 @@
 package users
 
+import "gonesoft/go-dev-portfolio/internal/validate"
+
 type User struct {
-	ID    int    `json:"id"`
-	Name  string `json:"name"`
-	Email string `json:"email"`
+	ID      int    `json:"id"`
+	Name    string `json:"name" validate:"trim,required,max=100,name"`
+	Email   string `json:"email" validate:"trim,required,email"`
+	Version int    `json:"version"`
+}
+
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "best_effort"
+)
+
+// BatchRequest is the body of POST /users:batch.
+type BatchRequest struct {
+	Mode       string           `json:"mode"`
+	Operations []BatchOperation `json:"operations"`
+}
+
+// BatchOperation is one create, update or delete inside a batch. Which of
+// the optional fields are required depends on Op (see validateBatchOperation).
+type BatchOperation struct {
+	Op      string `json:"op" validate:"required,oneof=create update delete"`
+	ID      int    `json:"id,omitempty" validate:"min=1"`
+	Name    string `json:"name,omitempty" validate:"trim,max=100,name"`
+	Email   string `json:"email,omitempty" validate:"trim,email"`
+	Version int    `json:"version,omitempty" validate:"min=1"`
+}
+
+// BatchItem is the repository outcome of one operation.
+type BatchItem struct {
+	User *User
+	Err  error
+}
+
+// BatchResult is the per-item entry of the batch response.
+type BatchResult struct {
+	Index  int             `json:"index"`
+	Op     string          `json:"op"`
+	Status int             `json:"status"`
+	User   *User           `json:"user,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Fields validate.Errors `json:"fields,omitempty"`
 }
@@ -1,15 +1,36 @@
 package users
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"sort"
 	"strings"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/audit"
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/events"
+	"gonesoft/go-dev-portfolio/internal/jobs"
+	"gonesoft/go-dev-portfolio/internal/logging"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+	"gonesoft/go-dev-portfolio/internal/validate"
+
+	"github.com/lib/pq"
 )
 
 var (
-	ErrInvalidSort  = errors.New("invalid sort field")
-	ErrInvalidOrder = errors.New("invalid sort order")
+	ErrInvalidSort     = errors.New("invalid sort field")
+	ErrInvalidOrder    = errors.New("invalid sort order")
+	ErrVersionMismatch = errors.New("user version mismatch")
+	ErrBatchAborted    = errors.New("batch rolled back")
+	ErrNotDeleted      = errors.New("user is not deleted")
+	ErrEmailExists     = errors.New("email already exists")
+
+	// errDryRun rolls back the transaction of a dry run
+	errDryRun = errors.New("dry run")
 )
 
 type ListOptions struct {
@@ -20,65 +41,44 @@ type ListOptions struct {
 	Order  string
 }
 
-func ListUsers(db *sql.DB, opt ListOptions) ([]User, int, error) {
+func ListUsers(ctx context.Context, q db.DBTX, opt ListOptions) ([]User, int, error) {
+	defer metrics.ObserveQuery("list_users", time.Now())
 	if opt.Limit <= 0 {
 		opt.Limit = 10
 	}
 	if opt.Offset < 0 {
 		opt.Offset = 0
 	}
-	if opt.SortBy == "" {
-		opt.SortBy = "id"
-	}
-	if opt.Order == "" {
-		opt.Order = "ASC"
-	}
-
-	allowedSort := map[string]bool{
-		"id":         true,
-		"name":       true,
-		"email":      true,
-		"created_at": true,
-	}
-	if !allowedSort[strings.ToLower(opt.SortBy)] {
-		return nil, 0, ErrInvalidSort
-	}
-
-	sortCol := strings.ToLower(opt.SortBy)
-
-	order := strings.ToUpper(opt.Order)
-	if order != "ASC" && order != "DESC" {
-		return nil, 0, ErrInvalidOrder
-	}
-
-	//search term
-	search := "%"
-	if strings.TrimSpace(opt.Search) != "" {
-		search = "%" + strings.ToLower(strings.TrimSpace(opt.Search)) + "%"
+	search, sortCol, order, err := listFilter(opt)
+	if err != nil {
+		return nil, 0, err
 	}
+	canonical := emailSearch(opt.Search)
+	logging.FromContext(ctx).Debug("listing users",
+		"sort", sortCol, "order", order, "limit", opt.Limit, "offset", opt.Offset)
 
 	var total int
-	if err := db.QueryRow(`
+	if err := q.QueryRowContext(ctx, `
 		SELECT COUNT(*)
 		FROM users
 		WHERE deleted_at IS NULL
-		AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $2)
-	`, search, search).Scan(&total); err != nil {
+		AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $1 OR email_canonical LIKE $2)
+	`, search, canonical).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 
 	// Page data
 	// ORDER BY must be injected *after* validation (no placeholders allowed for identifiers)
 	query := fmt.Sprintf(`
-		SELECT id, name, email
+		SELECT id, name, email, version
 		FROM users
 		WHERE deleted_at IS NULL
-		  AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $1)
+		  AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $1 OR email_canonical LIKE $4)
 		ORDER BY %s %s
 		LIMIT $2 OFFSET $3
 	`, sortCol, order)
 
-	rows, err := db.Query(query, search, opt.Limit, opt.Offset)
+	rows, err := q.QueryContext(ctx, query, search, opt.Limit, opt.Offset, canonical)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -87,7 +87,7 @@ func ListUsers(db *sql.DB, opt ListOptions) ([]User, int, error) {
 	var out []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.ID, &u.Name, &u.Email); err != nil {
+		if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Version); err != nil {
 			return nil, 0, err
 		}
 		out = append(out, u)
@@ -99,7 +99,44 @@ func ListUsers(db *sql.DB, opt ListOptions) ([]User, int, error) {
 	return out, total, nil
 }
 
-func GetUsersFromDB(db *sql.DB, search string, limit, offset int, sortBy, order string) ([]User, int, error) {
+// listFilter validates the search and sort options shared by ListUsers and
+// ExportUsersFromDB and returns the LIKE pattern, sort column and direction.
+func listFilter(opt ListOptions) (string, string, string, error) {
+	if opt.SortBy == "" {
+		opt.SortBy = "id"
+	}
+	if opt.Order == "" {
+		opt.Order = "ASC"
+	}
+
+	allowedSort := map[string]bool{
+		"id":         true,
+		"name":       true,
+		"email":      true,
+		"created_at": true,
+	}
+	if !allowedSort[strings.ToLower(opt.SortBy)] {
+		return "", "", "", ErrInvalidSort
+	}
+
+	sortCol := strings.ToLower(opt.SortBy)
+
+	order := strings.ToUpper(opt.Order)
+	if order != "ASC" && order != "DESC" {
+		return "", "", "", ErrInvalidOrder
+	}
+
+	//search term
+	search := "%"
+	if strings.TrimSpace(opt.Search) != "" {
+		search = "%" + strings.ToLower(strings.TrimSpace(opt.Search)) + "%"
+	}
+	return search, sortCol, order, nil
+}
+
+func GetUsersFromDB(ctx context.Context, q db.DBTX, search string, limit, offset int, sortBy, order string) ([]User, int, error) {
+	defer metrics.ObserveQuery("get_users", time.Now())
+	canonical := emailSearch(search)
 	if search != "" {
 		search = "%" + strings.ToLower(search) + "%"
 	} else {
@@ -108,26 +145,26 @@ func GetUsersFromDB(db *sql.DB, search string, limit, offset int, sortBy, order
 
 	//Total count
 	var total int
-	err := db.QueryRow(`
+	err := q.QueryRowContext(ctx, `
 		SELECT COUNT(*)
 		FROM users
 		WHERE deleted_at IS NULL
-		AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $2)
-	`, search, search).Scan(&total)
+		AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $1 OR email_canonical LIKE $2)
+	`, search, canonical).Scan(&total)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	//Data retrieval
 	query := `
-		SELECT id, name, email
+		SELECT id, name, email, version
 		FROM users
 		WHERE deleted_at IS NULL
-		AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $1)
+		AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $1 OR email_canonical LIKE $4)
 		ORDER BY ` + sortBy + ` ` + order + `
 		LIMIT $2 OFFSET $3
 	`
-	rows, err := db.Query(query, search, limit, offset)
+	rows, err := q.QueryContext(ctx, query, search, limit, offset, canonical)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -136,7 +173,7 @@ func GetUsersFromDB(db *sql.DB, search string, limit, offset int, sortBy, order
 	var usersList []User
 	for rows.Next() {
 		var user User
-		if err := rows.Scan(&user.ID, &user.Name, &user.Email); err != nil {
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Version); err != nil {
 			return nil, 0, err
 		}
 		usersList = append(usersList, user)
@@ -144,68 +181,309 @@ func GetUsersFromDB(db *sql.DB, search string, limit, offset int, sortBy, order
 	return usersList, total, nil
 }
 
-func UpdateUserFromDB(db *sql.DB, id int, user *User) error {
+// UpdateUserFromDB overwrites name and email of the user with the given ID.
+// The stored version must be one of expectedVersions (nil skips the check); on
+// success user.Version holds the new version.
+func UpdateUserFromDB(ctx context.Context, q db.DBTX, id int, user *User, expectedVersions []int) error {
+	defer metrics.ObserveQuery("update_user", time.Now())
+	if err := updateUser(ctx, q, id, user, expectedVersions); err != nil {
+		return err
+	}
+	invalidateUsers(ctx, id)
+	return nil
+}
+
+// emailTaken turns a unique violation on users, raised when a concurrent
+// request claimed the email after the check, into ErrEmailExists.
+func emailTaken(err error, email string) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Table == "users" {
+		return fmt.Errorf("%w: %s", ErrEmailExists, email)
+	}
+	return err
+}
+
+// emailTakenQuery reports whether a live user other than $2 has the canonical email $1.
+const emailTakenQuery = `SELECT EXISTS(SELECT 1 FROM users
+	WHERE email_canonical = $1 AND id != $2 AND deleted_at IS NULL)`
+
+func updateUser(ctx context.Context, q db.DBTX, id int, user *User, expectedVersions []int) error {
+	canonical, err := canonicalEmail(user.Email)
+	if err != nil {
+		return err
+	}
 	//check if email exists
 	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = $1 AND id != $2)", user.Email, id).Scan(&exists)
+	err = q.QueryRowContext(ctx, emailTakenQuery, canonical, id).Scan(&exists)
 	if err != nil {
 		return err
 	}
 	if exists {
-		return fmt.Errorf("email %s already exists", user.Email)
+		return fmt.Errorf("%w: %s", ErrEmailExists, user.Email)
 	}
 	// Validate ID
 	if id <= 0 {
 		return fmt.Errorf("invalid user ID: %d", id)
 	}
 
-	// Update user
-	result, err := db.Exec("UPDATE users SET name = $1, email = $2 WHERE id = $3 AND deleted_at IS NULL", user.Name, user.Email, id)
-	if err != nil {
-		return err
+	return db.WithTx(ctx, q, func(tx db.DBTX) error {
+		before, err := lockUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		// Update user
+		err = tx.QueryRowContext(ctx, `
+			UPDATE users SET name = $1, email = $2, email_canonical = $5, version = version + 1
+			WHERE id = $3 AND deleted_at IS NULL AND ($4::int[] IS NULL OR version = ANY($4))
+			RETURNING version
+		`, user.Name, user.Email, id, pq.Array(expectedVersions), canonical).Scan(&user.Version)
+		if err == sql.ErrNoRows {
+			return versionConflict(ctx, tx, id)
+		}
+		if err != nil {
+			return emailTaken(err, user.Email)
+		}
+		user.ID = id
+		if err := recordUpdate(ctx, tx, before, *user); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Info("user updated", "user_id", id, "version", user.Version, "email", user.Email)
+		return nil
+	})
+}
+
+// UserChanges holds the columns touched by a partial update; nil fields are left as is.
+type UserChanges struct {
+	Name  *string
+	Email *string
+}
+
+// PatchUserInDB updates only the columns set in changes and returns the stored user.
+// expectedVersion must match the stored version (0 skips the check).
+func PatchUserInDB(ctx context.Context, q db.DBTX, id int, changes UserChanges, expectedVersion int) (User, error) {
+	defer metrics.ObserveQuery("patch_user", time.Now())
+	if id <= 0 {
+		return User{}, sql.ErrNoRows
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	var sets []string
+	var args []interface{}
+	if changes.Email != nil {
+		canonical, err := canonicalEmail(*changes.Email)
+		if err != nil {
+			return User{}, err
+		}
+		var exists bool
+		err = q.QueryRowContext(ctx, emailTakenQuery, canonical, id).Scan(&exists)
+		if err != nil {
+			return User{}, err
+		}
+		if exists {
+			return User{}, fmt.Errorf("%w: %s", ErrEmailExists, *changes.Email)
+		}
+		args = append(args, *changes.Email, canonical)
+		sets = append(sets, fmt.Sprintf("email = $%d, email_canonical = $%d", len(args)-1, len(args)))
+	}
+	if changes.Name != nil {
+		args = append(args, *changes.Name)
+		sets = append(sets, fmt.Sprintf("name = $%d", len(args)))
+	}
+	sets = append(sets, "version = version + 1")
+	args = append(args, id, expectedVersion)
+
+	// column names come from the fixed list above, values stay in placeholders
+	query := fmt.Sprintf(`
+		UPDATE users SET %s
+		WHERE id = $%d AND deleted_at IS NULL AND ($%d = 0 OR version = $%d)
+		RETURNING id, name, email, version
+	`, strings.Join(sets, ", "), len(args)-1, len(args), len(args))
+
+	var user User
+	err := db.WithTx(ctx, q, func(tx db.DBTX) error {
+		before, err := lockUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Name, &user.Email, &user.Version)
+		if err == sql.ErrNoRows {
+			return versionConflict(ctx, tx, id)
+		}
+		if err != nil && changes.Email != nil {
+			return emailTaken(err, *changes.Email)
+		}
+		if err != nil {
+			return err
+		}
+		return recordUpdate(ctx, tx, before, user)
+	})
 	if err != nil {
-		return err
+		return User{}, err
 	}
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	invalidateUsers(ctx, id)
+	logging.FromContext(ctx).Info("user patched", "user_id", id, "version", user.Version,
+		"name_changed", changes.Name != nil, "email_changed", changes.Email != nil)
+	return user, nil
+}
+
+// DeleteUserFromDB soft-deletes the user with the given ID.
+// The stored version must be one of expectedVersions (nil skips the check).
+func DeleteUserFromDB(ctx context.Context, q db.DBTX, id int, expectedVersions []int) error {
+	defer metrics.ObserveQuery("delete_user", time.Now())
+	if err := deleteUser(ctx, q, id, expectedVersions); err != nil {
+		return err
 	}
+	invalidateUsers(ctx, id)
+	metrics.UsersDeleted.Inc()
 	return nil
 }
 
-func DeleteUserFromDB(db *sql.DB, id int) error {
+func deleteUser(ctx context.Context, q db.DBTX, id int, expectedVersions []int) error {
 	// Validate ID
 	if id <= 0 {
 		return sql.ErrNoRows
 	}
 
-	// Soft delete user
-	result, err := db.Exec("UPDATE users SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL", id)
+	return db.WithTx(ctx, q, func(tx db.DBTX) error {
+		before, err := lockUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		// Soft delete user
+		result, err := tx.ExecContext(ctx, `
+			UPDATE users SET deleted_at = NOW(), version = version + 1
+			WHERE id = $1 AND deleted_at IS NULL AND ($2::int[] IS NULL OR version = ANY($2))
+		`, id, pq.Array(expectedVersions))
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return versionConflict(ctx, tx, id)
+		}
+		if err := recordMutation(ctx, tx, audit.Entry{Action: audit.ActionDelete, UserID: id, Before: auditState(before)},
+			events.UserDeleted{ID: id}); err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Info("user deleted", "user_id", id)
+		return nil
+	})
+}
+
+// RestoreUserFromDB undoes the soft delete of the user with the given ID and
+// returns it. The stored version must be one of expectedVersions (nil skips the
+// check). Restoring fails if another live user has taken the email in the meantime.
+func RestoreUserFromDB(ctx context.Context, q db.DBTX, id int, expectedVersions []int) (User, error) {
+	defer metrics.ObserveQuery("restore_user", time.Now())
+	if id <= 0 {
+		return User{}, sql.ErrNoRows
+	}
+
+	var user User
+	err := db.WithTx(ctx, q, func(tx db.DBTX) error {
+		var deleted bool
+		var version int
+		var email string
+		err := tx.QueryRowContext(ctx, `SELECT deleted_at IS NOT NULL, version, email FROM users
+			WHERE id = $1 FOR UPDATE`, id).Scan(&deleted, &version, &email)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return ErrNotDeleted
+		}
+		if expectedVersions != nil && !slices.Contains(expectedVersions, version) {
+			return ErrVersionMismatch
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			UPDATE users SET deleted_at = NULL, version = version + 1
+			WHERE id = $1
+			RETURNING id, name, email, version
+		`, id).Scan(&user.ID, &user.Name, &user.Email, &user.Version)
+		if err != nil {
+			return emailTaken(err, email)
+		}
+		return recordMutation(ctx, tx, audit.Entry{Action: audit.ActionRestore, UserID: id, After: auditState(user)},
+			events.UserRestored{ID: user.ID, Name: user.Name, Email: user.Email, Version: user.Version})
+	})
 	if err != nil {
+		return User{}, err
+	}
+	invalidateUsers(ctx, id)
+	logging.FromContext(ctx).Info("user restored", "user_id", id, "version", user.Version)
+	return user, nil
+}
+
+// lockUser reads a live user and locks its row until the transaction ends, so
+// the audit event sees the state the mutation replaces.
+func lockUser(ctx context.Context, q db.DBTX, id int) (User, error) {
+	var user User
+	err := q.QueryRowContext(ctx, `SELECT id, name, email, version FROM users
+		WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, id).Scan(&user.ID, &user.Name, &user.Email, &user.Version)
+	return user, err
+}
+
+// recordMutation writes the audit event and the domain event of a change in
+// the transaction that makes it.
+func recordMutation(ctx context.Context, tx db.DBTX, entry audit.Entry, event events.Event) error {
+	if err := audit.Record(ctx, tx, entry); err != nil {
 		return err
 	}
+	return events.Enqueue(ctx, tx, event)
+}
+
+// recordUpdate records the change from before to after.
+func recordUpdate(ctx context.Context, tx db.DBTX, before, after User) error {
+	changes := audit.Diff(auditState(before), auditState(after))
+	changed := make([]string, 0, len(changes))
+	for field := range changes {
+		changed = append(changed, field)
+	}
+	sort.Strings(changed)
+	entry := audit.Entry{Action: audit.ActionUpdate, UserID: after.ID, Before: auditState(before), After: auditState(after)}
+	event := events.UserUpdated{ID: after.ID, Name: after.Name, Email: after.Email, Version: after.Version, Changed: changed}
+	return recordMutation(ctx, tx, entry, event)
+}
+
+func userCreated(u User) events.UserCreated {
+	return events.UserCreated{ID: u.ID, Name: u.Name, Email: u.Email, Version: u.Version}
+}
+
+// auditState is the part of a user recorded in the audit log.
+func auditState(u User) map[string]any {
+	return map[string]any{"name": u.Name, "email": u.Email}
+}
 
-	rowsAffected, err := result.RowsAffected()
+// versionConflict explains why a conditional write touched no rows: the user
+// is gone (sql.ErrNoRows) or it was changed by someone else (ErrVersionMismatch).
+func versionConflict(ctx context.Context, q db.DBTX, id int) error {
+	var exists bool
+	err := q.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists)
 	if err != nil {
 		return err
 	}
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	if exists {
+		return ErrVersionMismatch
 	}
-	return nil
+	return sql.ErrNoRows
 }
 
-func GetUserByIDFromDB(db *sql.DB, id int) (User, error) {
+func GetUserByIDFromDB(ctx context.Context, q db.DBTX, id int) (User, error) {
+	defer metrics.ObserveQuery("get_user_by_id", time.Now())
 	// Validate ID
 	if id <= 0 {
 		return User{}, sql.ErrNoRows
 	}
 
 	var user User
-	err := db.QueryRow(`SELECT id, name, email FROM users 
-		WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&user.ID, &user.Name, &user.Email)
+	err := q.QueryRowContext(ctx, `SELECT id, name, email, version FROM users 
+		WHERE id = $1 AND deleted_at IS NULL`, id).Scan(&user.ID, &user.Name, &user.Email, &user.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return User{}, sql.ErrNoRows
@@ -215,15 +493,341 @@ func GetUserByIDFromDB(db *sql.DB, id int) (User, error) {
 	return user, nil
 }
 
-func CreateUserInDB(db *sql.DB, user *User) error {
-	if user.Name == "" || user.Email == "" {
-		return sql.ErrNoRows
+func CreateUserInDB(ctx context.Context, q db.DBTX, user *User) error {
+	defer metrics.ObserveQuery("create_user", time.Now())
+	if err := validate.Struct(user); err != nil {
+		return err
+	}
+	canonical, err := canonicalEmail(user.Email)
+	if err != nil {
+		return err
 	}
 
-	err := db.QueryRow("INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id",
-		user.Name, user.Email).Scan(&user.ID)
+	err = db.WithTx(ctx, q, func(tx db.DBTX) error {
+		err := tx.QueryRowContext(ctx, "INSERT INTO users (name, email, email_canonical) VALUES ($1, $2, $3) RETURNING id, version",
+			user.Name, user.Email, canonical).Scan(&user.ID, &user.Version)
+		if err != nil {
+			return emailTaken(err, user.Email)
+		}
+		err = recordMutation(ctx, tx, audit.Entry{Action: audit.ActionCreate, UserID: user.ID, After: auditState(*user)},
+			userCreated(*user))
+		if err != nil {
+			return err
+		}
+		_, err = jobs.Enqueue(ctx, tx, WelcomeEmail{UserID: user.ID})
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	metrics.UsersCreated.Inc()
+	logging.FromContext(ctx).Info("user created", "user_id", user.ID, "email", user.Email)
 	return nil
 }
+
+// insertChunkSize keeps multi-row INSERTs well below Postgres' 65535 parameter limit.
+const insertChunkSize = 1000
+
+// insertUsers creates users with multi-row INSERTs. Rows whose email is already
+// taken are skipped; created reports which users were inserted.
+func insertUsers(ctx context.Context, q db.DBTX, users []*User) ([]bool, error) {
+	var inserted []bool
+	err := db.WithTx(ctx, q, func(tx db.DBTX) error {
+		inserted = make([]bool, len(users))
+		for start := 0; start < len(users); start += insertChunkSize {
+			end := min(start+insertChunkSize, len(users))
+
+			var values []string
+			var args []interface{}
+			position := map[string]int{}
+			for i, user := range users[start:end] {
+				canonical, err := canonicalEmail(user.Email)
+				if err != nil {
+					return err
+				}
+				args = append(args, user.Name, user.Email, canonical)
+				values = append(values, fmt.Sprintf("($%d, $%d, $%d)", len(args)-2, len(args)-1, len(args)))
+				position[user.Email] = start + i
+			}
+
+			rows, err := tx.QueryContext(ctx, `
+				INSERT INTO users (name, email, email_canonical) VALUES `+strings.Join(values, ", ")+`
+				ON CONFLICT DO NOTHING
+				RETURNING id, email, version
+			`, args...)
+			if err != nil {
+				return err
+			}
+			for rows.Next() {
+				var id, version int
+				var email string
+				if err := rows.Scan(&id, &email, &version); err != nil {
+					rows.Close()
+					return err
+				}
+				i := position[email]
+				users[i].ID, users[i].Version = id, version
+				inserted[i] = true
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+		var entries []audit.Entry
+		var created []events.Event
+		var welcome []jobs.Job
+		for i, ok := range inserted {
+			if ok {
+				entries = append(entries, audit.Entry{Action: audit.ActionCreate, UserID: users[i].ID, After: auditState(*users[i])})
+				created = append(created, userCreated(*users[i]))
+				welcome = append(welcome, WelcomeEmail{UserID: users[i].ID})
+			}
+		}
+		if err := audit.RecordAll(ctx, tx, entries); err != nil {
+			return err
+		}
+		if err := events.Enqueue(ctx, tx, created...); err != nil {
+			return err
+		}
+		return jobs.EnqueueAll(ctx, tx, welcome)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return inserted, nil
+}
+
+// ExecuteBatch runs ops in order and reports the outcome of each one. In atomic
+// mode all operations share a transaction that is rolled back on the first
+// failure, leaving every other item marked ErrBatchAborted.
+func ExecuteBatch(ctx context.Context, q db.DBTX, ops []BatchOperation, atomic bool) ([]BatchItem, error) {
+	defer metrics.ObserveQuery("execute_batch", time.Now())
+	logging.FromContext(ctx).Info("executing user batch", "operations", len(ops), "atomic", atomic)
+	items := make([]BatchItem, len(ops))
+	if !atomic {
+		runBatch(ctx, q, ops, items, false)
+		countBatch(ctx, ops, items)
+		return items, nil
+	}
+
+	err := db.WithTx(ctx, q, func(tx db.DBTX) error {
+		clear(items)
+		if failed := runBatch(ctx, tx, ops, items, true); failed {
+			return ErrBatchAborted
+		}
+		return nil
+	})
+	if errors.Is(err, ErrBatchAborted) {
+		for i := range items {
+			if items[i].Err == nil {
+				items[i] = BatchItem{Err: ErrBatchAborted}
+			}
+		}
+		return items, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	countBatch(ctx, ops, items)
+	return items, nil
+}
+
+// countBatch updates the user lifecycle counters for the committed items of a
+// batch and drops the users it changed from the cache.
+func countBatch(ctx context.Context, ops []BatchOperation, items []BatchItem) {
+	var changed []int
+	for i, item := range items {
+		if item.Err != nil {
+			continue
+		}
+		switch ops[i].Op {
+		case BatchCreate:
+			metrics.UsersCreated.Inc()
+		case BatchUpdate:
+			changed = append(changed, ops[i].ID)
+		case BatchDelete:
+			metrics.UsersDeleted.Inc()
+			changed = append(changed, ops[i].ID)
+		}
+	}
+	invalidateUsers(ctx, changed...)
+}
+
+// runBatch fills items from ops. Consecutive creates are sent as one multi-row
+// INSERT. It returns true if it stopped early because of stopOnError.
+func runBatch(ctx context.Context, q db.DBTX, ops []BatchOperation, items []BatchItem, stopOnError bool) bool {
+	seen := map[string]bool{}
+	for i := 0; i < len(ops); {
+		if ops[i].Op == BatchCreate {
+			j := i
+			var pending []*User
+			var indexes []int
+			for ; j < len(ops) && ops[j].Op == BatchCreate; j++ {
+				key, _ := canonicalEmail(ops[j].Email)
+				if seen[key] {
+					items[j].Err = fmt.Errorf("%w: %s", ErrEmailExists, ops[j].Email)
+					continue
+				}
+				seen[key] = true
+				pending = append(pending, &User{Name: ops[j].Name, Email: ops[j].Email})
+				indexes = append(indexes, j)
+			}
+
+			created, err := insertUsers(ctx, q, pending)
+			for k, idx := range indexes {
+				switch {
+				case err != nil:
+					items[idx].Err = err
+				case created[k]:
+					items[idx].User = pending[k]
+				default:
+					items[idx].Err = fmt.Errorf("%w: %s", ErrEmailExists, pending[k].Email)
+				}
+			}
+			if stopOnError {
+				for k := i; k < j; k++ {
+					if items[k].Err != nil {
+						return true
+					}
+				}
+			}
+			i = j
+			continue
+		}
+
+		op := ops[i]
+		switch op.Op {
+		case BatchUpdate:
+			user := &User{Name: op.Name, Email: op.Email}
+			if err := updateUser(ctx, q, op.ID, user, []int{op.Version}); err != nil {
+				items[i].Err = err
+			} else {
+				items[i].User = user
+			}
+		case BatchDelete:
+			items[i].Err = deleteUser(ctx, q, op.ID, []int{op.Version})
+		default:
+			items[i].Err = fmt.Errorf("unknown batch op %q", op.Op)
+		}
+		if items[i].Err != nil && stopOnError {
+			return true
+		}
+		i++
+	}
+	return false
+}
+
+// exportFetchSize is the number of rows pulled from the cursor per round trip.
+const exportFetchSize = 500
+
+// ExportUsersFromDB streams every user matching the search and sort of opt to fn,
+// reading through a server-side cursor so the result set is never held in memory.
+// Limit and Offset are ignored.
+func ExportUsersFromDB(ctx context.Context, q db.DBTX, opt ListOptions, fn func(User) error) error {
+	defer metrics.ObserveQuery("export_users", time.Now())
+	search, sortCol, order, err := listFilter(opt)
+	if err != nil {
+		return err
+	}
+
+	return db.WithTx(ctx, q, func(tx db.DBTX) error {
+		// ORDER BY must be injected *after* validation (no placeholders allowed for identifiers)
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+			DECLARE users_export NO SCROLL CURSOR FOR
+			SELECT id, name, email, version
+			FROM users
+			WHERE deleted_at IS NULL
+			  AND (LOWER(name) LIKE $1 OR LOWER(email) LIKE $1 OR email_canonical LIKE $2)
+			ORDER BY %s %s, id
+		`, sortCol, order), search, emailSearch(opt.Search))
+		if err != nil {
+			return err
+		}
+		defer tx.ExecContext(ctx, "CLOSE users_export")
+
+		for {
+			rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH FORWARD %d FROM users_export", exportFetchSize))
+			if err != nil {
+				return err
+			}
+			fetched := 0
+			for rows.Next() {
+				var u User
+				if err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Version); err != nil {
+					rows.Close()
+					return err
+				}
+				fetched++
+				if err := fn(u); err != nil {
+					rows.Close()
+					return err
+				}
+			}
+			err = rows.Err()
+			rows.Close()
+			if err != nil {
+				return err
+			}
+			if fetched < exportFetchSize {
+				return nil
+			}
+		}
+	}, db.ReadOnly(), db.Attempts(1))
+}
+
+// ImportUsersInDB inserts users with multi-row INSERTs inside one transaction.
+// created[i] reports whether users[i] was inserted or skipped because its email
+// is taken. In dry-run mode the transaction is rolled back.
+func ImportUsersInDB(ctx context.Context, q db.DBTX, users []*User, dryRun bool) ([]bool, error) {
+	defer metrics.ObserveQuery("import_users", time.Now())
+	var created []bool
+	err := db.WithTx(ctx, q, func(tx db.DBTX) error {
+		var err error
+		created, err = insertUsers(ctx, tx, users)
+		if err != nil {
+			return err
+		}
+		logging.FromContext(ctx).Info("importing users", "rows", len(users), "dry_run", dryRun)
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if dryRun && errors.Is(err, errDryRun) {
+		return created, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, ok := range created {
+		if ok {
+			metrics.UsersCreated.Inc()
+		}
+	}
+	return created, nil
+}
+
+// PurgeDeletedUsers permanently removes users soft-deleted more than
+// olderThan ago, after which they can no longer be restored. It returns how
+// many were removed.
+func PurgeDeletedUsers(ctx context.Context, q db.DBTX, olderThan time.Duration) (int64, error) {
+	defer metrics.ObserveQuery("purge_deleted_users", time.Now())
+	result, err := q.ExecContext(ctx, `
+		DELETE FROM users
+		WHERE deleted_at < NOW() - MAKE_INTERVAL(secs => $1)
+	`, olderThan.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		logging.FromContext(ctx).Info("purged deleted users", "count", n)
+	}
+	return n, nil
+}
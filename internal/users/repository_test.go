@@ -1,21 +1,30 @@
 package users
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"gonesoft/go-dev-portfolio/internal/audit"
+	"gonesoft/go-dev-portfolio/internal/cache"
 	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/tracing"
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	//"github.com/go-playground/assert/v2"
 	_ "github.com/lib/pq" // PostgreSQL driver
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 func TestMain(m *testing.M) {
-	testDB := db.Connect()
-	if testDB == nil {
-		log.Fatal("Failed to connect to the test database")
+	testDB, err := db.Connect()
+	if err != nil {
+		log.Fatal("Failed to connect to the test database: ", err)
 	}
 	defer testDB.Close()
 
@@ -25,18 +34,20 @@ func TestMain(m *testing.M) {
 }
 
 func TestCreateUserAndFetch(t *testing.T) {
-	testDB := db.Connect()
+	testDB, _ := db.Connect()
+	ctx := context.Background()
 	_, err := testDB.Exec(`INSERT INTO users (name, email) VALUES ($1, $2)`, "Test User", "test@example4.com")
 	assert.NoError(t, err, "Failed to insert user")
 
-	usersList, total, err := GetUsersFromDB(testDB, "Test User", 10, 0, "name", "ASC")
+	usersList, total, err := GetUsersFromDB(ctx, testDB, "Test User", 10, 0, "name", "ASC")
 	assert.NoError(t, err, "Failed to fetch users")
 	assert.Equal(t, 1, total, "Expected 1 user to be returned")
 	assert.Equal(t, "Test User", usersList[0].Name, "User name does not match")
 }
 
 func TestUpdateUser(t *testing.T) {
-	testDB := db.Connect()
+	testDB, _ := db.Connect()
+	ctx := context.Background()
 
 	var id int
 	user := User{
@@ -47,8 +58,9 @@ func TestUpdateUser(t *testing.T) {
 		VALUES ($1, $2) RETURNING id`, "Old Nme", "old@example.com").Scan(&id)
 	assert.NoError(t, err, "Failed to insert user")
 
-	err = UpdateUserFromDB(testDB, id, &user)
+	err = UpdateUserFromDB(ctx, testDB, id, &user, []int{1})
 	assert.NoError(t, err, "Failed to update user")
+	assert.Equal(t, 2, user.Version, "Update should bump the version")
 
 	var udatedName, updatedEmail string
 	err = testDB.QueryRow(`SELECT name, email FROM users WHERE id = $1`, id).Scan(&udatedName, &updatedEmail)
@@ -57,14 +69,15 @@ func TestUpdateUser(t *testing.T) {
 	assert.Equal(t, "new@example.com", updatedEmail, "User email was not updated correctly")
 }
 func TestDeleteUser(t *testing.T) {
-	testDB := db.Connect()
+	testDB, _ := db.Connect()
+	ctx := context.Background()
 
 	var id int
 	err := testDB.QueryRow(`INSERT INTO users (name, email) 
 		VALUES ($1, $2) RETURNING id`, "Delete Me", "delete@example.com").Scan(&id)
 	assert.NoError(t, err, "Failed to insert user for deletion")
 
-	err = DeleteUserFromDB(testDB, id)
+	err = DeleteUserFromDB(ctx, testDB, id, nil)
 	assert.NoError(t, err, "Failed to delete user")
 
 	var deletedAt sql.NullTime
@@ -73,32 +86,79 @@ func TestDeleteUser(t *testing.T) {
 	assert.True(t, deletedAt.Valid, "User should be soft-deleted")
 }
 
+func TestUpdateUserVersionMismatch(t *testing.T) {
+	testDB, _ := db.Connect()
+	ctx := context.Background()
+
+	var id int
+	err := testDB.QueryRow(`INSERT INTO users (name, email) 
+		VALUES ($1, $2) RETURNING id`, "Stale Writer", "stale@example.com").Scan(&id)
+	assert.NoError(t, err, "Failed to insert user")
+
+	first := User{Name: "First Writer", Email: "stale@example.com"}
+	err = UpdateUserFromDB(ctx, testDB, id, &first, []int{1})
+	assert.NoError(t, err, "First update should succeed")
+
+	second := User{Name: "Second Writer", Email: "stale@example.com"}
+	err = UpdateUserFromDB(ctx, testDB, id, &second, []int{1})
+	assert.ErrorIs(t, err, ErrVersionMismatch, "Stale version should be rejected")
+
+	err = DeleteUserFromDB(ctx, testDB, id, []int{1})
+	assert.ErrorIs(t, err, ErrVersionMismatch, "Stale version should not delete")
+
+	err = DeleteUserFromDB(ctx, testDB, 999999, []int{1})
+	assert.ErrorIs(t, err, sql.ErrNoRows, "Missing user should be not found")
+}
+
+func TestPatchUserInDB(t *testing.T) {
+	testDB, _ := db.Connect()
+	ctx := context.Background()
+
+	var id int
+	err := testDB.QueryRow(`INSERT INTO users (name, email) 
+		VALUES ($1, $2) RETURNING id`, "Patch Me", "patch@example.com").Scan(&id)
+	assert.NoError(t, err, "Failed to insert user")
+
+	name := "Patched"
+	user, err := PatchUserInDB(ctx, testDB, id, UserChanges{Name: &name}, 1)
+	assert.NoError(t, err, "Failed to patch user")
+	assert.Equal(t, "Patched", user.Name, "Name should be patched")
+	assert.Equal(t, "patch@example.com", user.Email, "Email should be unchanged")
+	assert.Equal(t, 2, user.Version, "Patch should bump the version")
+
+	_, err = PatchUserInDB(ctx, testDB, id, UserChanges{Name: &name}, 1)
+	assert.ErrorIs(t, err, ErrVersionMismatch, "Stale version should be rejected")
+}
+
 func TestGetUserByID(t *testing.T) {
-	testDB := db.Connect()
+	testDB, _ := db.Connect()
+	ctx := context.Background()
 
 	var id int
 	err := testDB.QueryRow(`INSERT INTO users (name, email) 
 		VALUES ($1, $2) RETURNING id`, "New User", "newemail@example.com").Scan(&id)
 	assert.NoError(t, err, "Failed to insert user for fetching")
-	user, err := GetUserByIDFromDB(testDB, id)
+	user, err := GetUserByIDFromDB(ctx, testDB, id)
 	assert.NoError(t, err, "Failed to fetch user by ID")
 	assert.Equal(t, "New User", user.Name, "User name does not match")
 
 }
 
 func TestCreateUserInDB(t *testing.T) {
-	testDB := db.Connect()
+	testDB, _ := db.Connect()
+	ctx := context.Background()
 
 	var user User
 	user.Name = "Test User"
 	user.Email = "testuser@example.com"
-	err := CreateUserInDB(testDB, &user)
+	err := CreateUserInDB(ctx, testDB, &user)
 	assert.NoError(t, err, "Failed to insert user")
 	assert.Greater(t, user.ID, 0, "User ID should be greater than 0")
 }
 
 func TestListUserNoPaging(t *testing.T) {
-	conn := db.Connect()
+	conn, _ := db.Connect()
+	ctx := context.Background()
 	_, _ = conn.Exec("DELETE FROM users") // Clear the table before testing
 
 	names := []string{"Alice", "Bob", "Charlie"}
@@ -108,23 +168,214 @@ func TestListUserNoPaging(t *testing.T) {
 		assert.NoError(t, err, "Failed to insert user")
 	}
 
-	res, total, err := ListUsers(conn, ListOptions{Limit: 2, Offset: 0, SortBy: "name", Order: "ASC"})
+	res, total, err := ListUsers(ctx, conn, ListOptions{Limit: 2, Offset: 0, SortBy: "name", Order: "ASC"})
 	assert.NoError(t, err, "Failed to list users")
 	assert.Equal(t, 3, total, "Total users should be 3")
 	assert.Equal(t, 2, len(res), "Should return 2 users due to limit")
 	assert.Equal(t, "Alice", res[0].Name, "First user should be Alice")
 	assert.Equal(t, "Bob", res[1].Name, "Second user should be Bob")
 
-	res, _, err = ListUsers(conn, ListOptions{Limit: 2, Offset: 1, SortBy: "name", Order: "ASC"})
+	res, _, err = ListUsers(ctx, conn, ListOptions{Limit: 2, Offset: 1, SortBy: "name", Order: "ASC"})
 	assert.NoError(t, err, "Failed to list users with offset")
 	assert.Equal(t, 2, len(res), "Should return 2 users due to limit")
 	assert.Equal(t, "Bob", res[0].Name, "First user should be Bob")
 	assert.Equal(t, "Charlie", res[1].Name, "Second user should be Charlie")
 
-	_, _, err = ListUsers(conn, ListOptions{SortBy: "drop table users"})
+	_, _, err = ListUsers(ctx, conn, ListOptions{SortBy: "drop table users"})
 	assert.ErrorIs(t, err, ErrInvalidSort, "Should return error for invalid sort")
 
-	_, _, err = ListUsers(conn, ListOptions{SortBy: "id", Order: "SIDEWAYS"})
+	_, _, err = ListUsers(ctx, conn, ListOptions{SortBy: "id", Order: "SIDEWAYS"})
 	assert.ErrorIs(t, err, ErrInvalidOrder, "Should return error for invalid order")
 
 }
+
+func TestExecuteBatch(t *testing.T) {
+	conn, _ := db.Connect()
+	ctx := context.Background()
+	_, _ = conn.Exec("DELETE FROM users")
+
+	var existing int
+	err := conn.QueryRow(`INSERT INTO users (name, email) 
+		VALUES ($1, $2) RETURNING id`, "Existing", "existing@batch.com").Scan(&existing)
+	assert.NoError(t, err, "Failed to insert user")
+
+	t.Run("best effort keeps the good items", func(t *testing.T) {
+		items, err := ExecuteBatch(ctx, conn, []BatchOperation{
+			{Op: BatchCreate, Name: "One", Email: "one@batch.com"},
+			{Op: BatchCreate, Name: "Dup", Email: "existing@batch.com"},
+			{Op: BatchCreate, Name: "Two", Email: "two@batch.com"},
+			{Op: BatchCreate, Name: "Two Again", Email: "TWO@batch.com"},
+			{Op: BatchUpdate, ID: existing, Name: "Existing Updated", Email: "existing@batch.com", Version: 1},
+		}, false)
+		assert.NoError(t, err)
+		assert.NoError(t, items[0].Err)
+		assert.Greater(t, items[0].User.ID, 0, "Created user should have an ID")
+		assert.Error(t, items[1].Err, "Existing email should conflict")
+		assert.NoError(t, items[2].Err)
+		assert.Error(t, items[3].Err, "Duplicate inside the batch should conflict")
+		assert.NoError(t, items[4].Err)
+		assert.Equal(t, 2, items[4].User.Version)
+	})
+
+	t.Run("atomic rolls back on the first failure", func(t *testing.T) {
+		items, err := ExecuteBatch(ctx, conn, []BatchOperation{
+			{Op: BatchCreate, Name: "Three", Email: "three@batch.com"},
+			{Op: BatchDelete, ID: existing, Version: 1},
+		}, true)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, items[0].Err, ErrBatchAborted)
+		assert.ErrorIs(t, items[1].Err, ErrVersionMismatch)
+
+		var count int
+		_ = conn.QueryRow(`SELECT COUNT(*) FROM users WHERE email = 'three@batch.com'`).Scan(&count)
+		assert.Equal(t, 0, count, "Rolled back create should not be persisted")
+	})
+}
+
+func TestRepositorySpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := tracing.NewProvider(recorder)
+	otel.SetTracerProvider(provider)
+	defer provider.Shutdown(context.Background())
+
+	conn, _ := db.Connect()
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "GET /users")
+	_, _, err := ListUsers(ctx, conn, ListOptions{Search: "o'brien", Limit: 5})
+	assert.NoError(t, err)
+	parent.End()
+
+	var statements []string
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			continue
+		}
+		for _, attr := range span.Attributes() {
+			if attr.Key == semconv.DBQueryTextKey {
+				statements = append(statements, attr.Value.AsString())
+			}
+		}
+	}
+	assert.Len(t, statements, 2, "COUNT and page query should each get a child span")
+	for _, stmt := range statements {
+		assert.NotContains(t, stmt, "brien", "Statements must not carry argument values")
+	}
+}
+
+func TestRepositoryInTransaction(t *testing.T) {
+	conn, _ := db.Connect()
+	ctx := context.Background()
+	rollback := errors.New("rollback")
+
+	err := db.WithTx(ctx, conn, func(tx db.DBTX) error {
+		user := User{Name: "Tx User", Email: "tx@example.com"}
+		if err := CreateUserInDB(ctx, tx, &user); err != nil {
+			return err
+		}
+		user.Name = "Tx User Renamed"
+		if err := UpdateUserFromDB(ctx, tx, user.ID, &user, []int{user.Version}); err != nil {
+			return err
+		}
+		return rollback
+	})
+	assert.ErrorIs(t, err, rollback)
+
+	var count int
+	_ = conn.QueryRow(`SELECT COUNT(*) FROM users WHERE email = 'tx@example.com'`).Scan(&count)
+	assert.Equal(t, 0, count, "Both steps should be rolled back together")
+}
+
+func TestRestoreUserFromDB(t *testing.T) {
+	conn, _ := db.Connect()
+	ctx := context.Background()
+
+	user := User{Name: "Restore Me", Email: "restore@example.com"}
+	assert.NoError(t, CreateUserInDB(ctx, conn, &user))
+
+	_, err := RestoreUserFromDB(ctx, conn, user.ID, nil)
+	assert.ErrorIs(t, err, ErrNotDeleted, "Live user cannot be restored")
+
+	assert.NoError(t, DeleteUserFromDB(ctx, conn, user.ID, []int{user.Version}))
+	restored, err := RestoreUserFromDB(ctx, conn, user.ID, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "restore@example.com", restored.Email)
+	assert.Equal(t, 3, restored.Version)
+
+	events, _, err := audit.ListEvents(ctx, conn, audit.ListOptions{UserID: user.ID})
+	assert.NoError(t, err)
+	var actions []string
+	for _, e := range events {
+		actions = append(actions, e.Action)
+	}
+	assert.Equal(t, []string{audit.ActionRestore, audit.ActionDelete, audit.ActionCreate}, actions,
+		"Every mutation should leave an audit event")
+}
+
+func TestCanonicalEmailIdentity(t *testing.T) {
+	conn, _ := db.Connect()
+	ctx := context.Background()
+	t.Setenv("EMAIL_PROVIDER_RULES", "true")
+
+	ada := User{Name: "Ada", Email: "Ada.Lovelace@Gmail.com"}
+	assert.NoError(t, CreateUserInDB(ctx, conn, &ada))
+	assert.Error(t, CreateUserInDB(ctx, conn, &User{Name: "Ada Again", Email: "adalovelace+news@googlemail.com"}),
+		"Another spelling of the same mailbox should conflict")
+
+	bob := User{Name: "Bob", Email: "bob@example.com"}
+	assert.NoError(t, CreateUserInDB(ctx, conn, &bob))
+	bob.Email = "ADA.LOVELACE@gmail.com"
+	err := UpdateUserFromDB(ctx, conn, bob.ID, &bob, nil)
+	assert.ErrorIs(t, err, ErrEmailExists)
+	taken := "a.d.a.lovelace@gmail.com"
+	_, err = PatchUserInDB(ctx, conn, bob.ID, UserChanges{Email: &taken}, 0)
+	assert.ErrorIs(t, err, ErrEmailExists)
+
+	found, total, err := ListUsers(ctx, conn, ListOptions{Search: "adalovelace+x@GMAIL.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total, "Searching for an alias should find the user")
+	assert.Equal(t, "Ada.Lovelace@Gmail.com", found[0].Email, "The address is shown as typed")
+
+	// a partial address matches by substring of the canonical form
+	found, total, err = ListUsers(ctx, conn, ListOptions{Search: "a.Love.lace+news@googlemail.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total, "Searching for a dotted, tagged part of the address should find the user")
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, ada.ID, found[0].ID)
+	}
+
+	// rows written without the API get the lower-cased address until recanonicalized
+	var zoe int
+	err = conn.QueryRow(`INSERT INTO users (name, email) VALUES ($1, $2) RETURNING id`, "Zoë", "Zoë@Bücher.example").Scan(&zoe)
+	assert.NoError(t, err)
+	n, err := RecanonicalizeEmails(ctx, conn)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, n, int64(1))
+	var canonical string
+	_ = conn.QueryRow(`SELECT email_canonical FROM users WHERE id = $1`, zoe).Scan(&canonical)
+	assert.Equal(t, "zoë@xn--bcher-kva.example", canonical)
+}
+
+func TestLookupUserFillsCacheFromPrimary(t *testing.T) {
+	conn, _ := db.Connect()
+	ctx := context.Background()
+	UseCache(cache.New[User]("users_test", cache.NewLRU(16), time.Minute))
+	defer UseCache(nil)
+
+	user := User{Name: "Before", Email: "lagging@example.com"}
+	assert.NoError(t, CreateUserInDB(ctx, conn, &user))
+
+	// a repeatable-read snapshot taken before the update stands in for a lagging replica
+	stale, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer stale.Rollback()
+	_, err = GetUserByIDFromDB(ctx, stale, user.ID)
+	assert.NoError(t, err)
+
+	user.Name = "After"
+	assert.NoError(t, UpdateUserFromDB(ctx, conn, user.ID, &user, nil))
+
+	got, err := LookupUser(ctx, stale, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "After", got.Name, "A cache miss should not be filled from a lagging replica")
+}
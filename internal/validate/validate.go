@@ -0,0 +1,236 @@
+// Package validate checks request DTOs against rules declared in struct tags:
+//
+//	Name string `json:"name" validate:"trim,required,max=100,name"`
+//
+// Rules run in order. A field that fails required is not checked further, and
+// an empty optional field skips the remaining rules. Struct reports every
+// failing field at once, named by its JSON key.
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gonesoft/go-dev-portfolio/internal/emailaddr"
+)
+
+// FieldError is one failed rule.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors lists the failed fields of one value.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, f := range e {
+		parts[i] = f.Field + " " + f.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Add appends a failure for field.
+func (e Errors) Add(field, message string) Errors {
+	return append(e, FieldError{Field: field, Message: message})
+}
+
+// Err returns e as an error, or nil when it is empty.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+var (
+	ErrEmail = errors.New("must be a valid email address")
+	ErrName  = errors.New("may only contain letters, digits, spaces, apostrophes, hyphens and periods")
+)
+
+// rule checks v and returns a message when it fails. Rules that clean up a
+// value, like trim, modify v and return "".
+type rule func(v reflect.Value, param string) string
+
+var rules map[string]rule
+
+func init() {
+	rules = map[string]rule{
+		"trim":     trim,
+		"required": required,
+		"min":      minimum,
+		"max":      maximum,
+		"oneof":    oneOf,
+		"email":    stringRule(Email),
+		"name":     stringRule(Name),
+	}
+}
+
+// Struct validates the struct v points to. Trimming needs a pointer; the other
+// rules work on values too. It panics on an unknown rule, which is a bug in
+// the tag rather than bad input.
+func Struct(v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		panic("validate: " + rv.Type().String() + " is not a struct")
+	}
+
+	var errs Errors
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+		name := jsonName(field)
+		value := rv.Field(i)
+		for _, r := range strings.Split(tag, ",") {
+			key, param, _ := strings.Cut(r, "=")
+			check, ok := rules[key]
+			if !ok {
+				panic("validate: unknown rule " + key + " on " + rv.Type().String() + "." + field.Name)
+			}
+			if key != "trim" && key != "required" && value.IsZero() {
+				break
+			}
+			if msg := check(value, param); msg != "" {
+				errs = errs.Add(name, msg)
+				break
+			}
+		}
+	}
+	return errs.Err()
+}
+
+// Email checks s against the addr-spec syntax of RFC 5322. Display names,
+// comments and angle brackets are rejected, so s must be the bare address,
+// with its local part quoted only when it has to be.
+func Email(s string) error {
+	if len(s) > 254 {
+		return ErrEmail
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Name != "" || addr.String() != "<"+s+">" {
+		return ErrEmail
+	}
+	local := s[:strings.LastIndexByte(s, '@')]
+	if len(local) > 64 {
+		return ErrEmail
+	}
+	// the users table stores the canonical form next to the address
+	if _, err := emailaddr.Canonical(s, emailaddr.Options{}); err != nil {
+		return ErrEmail
+	}
+	return nil
+}
+
+// Name accepts letters and marks of any script, digits, spaces, apostrophes,
+// hyphens and periods.
+func Name(s string) error {
+	if !utf8.ValidString(s) {
+		return ErrName
+	}
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r), unicode.IsMark(r), unicode.IsDigit(r):
+		case r == ' ', r == '\'', r == '’', r == '-', r == '.':
+		default:
+			return ErrName
+		}
+	}
+	return nil
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func trim(v reflect.Value, _ string) string {
+	if v.Kind() == reflect.String && v.CanSet() {
+		v.SetString(strings.TrimSpace(v.String()))
+	}
+	return ""
+}
+
+func required(v reflect.Value, _ string) string {
+	if v.IsZero() {
+		return "is required"
+	}
+	return ""
+}
+
+func minimum(v reflect.Value, param string) string {
+	n := intParam(param)
+	switch v.Kind() {
+	case reflect.String:
+		if utf8.RuneCountInString(v.String()) < n {
+			return fmt.Sprintf("must be at least %d characters", n)
+		}
+	case reflect.Int, reflect.Int64:
+		if v.Int() < int64(n) {
+			return fmt.Sprintf("must be at least %d", n)
+		}
+	case reflect.Slice:
+		if v.Len() < n {
+			return fmt.Sprintf("must have at least %d items", n)
+		}
+	}
+	return ""
+}
+
+func maximum(v reflect.Value, param string) string {
+	n := intParam(param)
+	switch v.Kind() {
+	case reflect.String:
+		if utf8.RuneCountInString(v.String()) > n {
+			return fmt.Sprintf("must be at most %d characters", n)
+		}
+	case reflect.Int, reflect.Int64:
+		if v.Int() > int64(n) {
+			return fmt.Sprintf("must be at most %d", n)
+		}
+	case reflect.Slice:
+		if v.Len() > n {
+			return fmt.Sprintf("must have at most %d items", n)
+		}
+	}
+	return ""
+}
+
+func oneOf(v reflect.Value, param string) string {
+	allowed := strings.Fields(param)
+	for _, a := range allowed {
+		if fmt.Sprint(v.Interface()) == a {
+			return ""
+		}
+	}
+	return "must be one of " + strings.Join(allowed, ", ")
+}
+
+func stringRule(check func(string) error) rule {
+	return func(v reflect.Value, _ string) string {
+		if err := check(v.String()); err != nil {
+			return err.Error()
+		}
+		return ""
+	}
+}
+
+func intParam(param string) int {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		panic("validate: invalid rule parameter " + strconv.Quote(param))
+	}
+	return n
+}
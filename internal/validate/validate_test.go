@@ -0,0 +1,90 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type signup struct {
+	Name  string `json:"name" validate:"trim,required,max=20,name"`
+	Email string `json:"email,omitempty" validate:"trim,required,email"`
+	Plan  string `json:"plan" validate:"oneof=free pro"`
+	Seats int    `json:"seats" validate:"min=1,max=5"`
+	Note  string
+}
+
+func TestStructReportsAllFields(t *testing.T) {
+	in := signup{Name: "  ", Email: "not-an-email", Plan: "gold", Seats: 9}
+	err := Struct(&in)
+	require.Error(t, err)
+
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	assert.Equal(t, Errors{
+		{Field: "name", Message: "is required"},
+		{Field: "email", Message: ErrEmail.Error()},
+		{Field: "plan", Message: "must be one of free, pro"},
+		{Field: "seats", Message: "must be at most 5"},
+	}, errs)
+	assert.Equal(t, "name is required; email must be a valid email address; plan must be one of free, pro; seats must be at most 5", err.Error())
+}
+
+func TestStructTrimsAndSkipsEmptyOptionalFields(t *testing.T) {
+	in := signup{Name: "  Ada Lovelace ", Email: " ada@example.com\n"}
+	require.NoError(t, Struct(&in))
+	assert.Equal(t, "Ada Lovelace", in.Name)
+	assert.Equal(t, "ada@example.com", in.Email)
+}
+
+func TestStructCountsRunes(t *testing.T) {
+	assert.NoError(t, Struct(&signup{Name: "Zoë Ødegård", Email: "z@example.com"}))
+	assert.Error(t, Struct(&signup{Name: strings.Repeat("é", 21), Email: "z@example.com"}))
+}
+
+func TestStructPanicsOnUnknownRule(t *testing.T) {
+	type bad struct {
+		Name string `validate:"shiny"`
+	}
+	assert.Panics(t, func() { _ = Struct(&bad{Name: "x"}) })
+}
+
+func TestEmail(t *testing.T) {
+	valid := []string{
+		"ada@example.com",
+		"first.last+tag@sub.example.co.uk",
+		`"quoted local"@example.com`,
+		"user@localhost",
+	}
+	for _, s := range valid {
+		assert.NoError(t, Email(s), s)
+	}
+
+	invalid := []string{
+		"",
+		"plain",
+		"@example.com",
+		"ada@",
+		"ada@@example.com",
+		"ada example@example.com",
+		"Ada <ada@example.com>",
+		"ada@example.com (Ada)",
+		"ada..lovelace@example.com",
+		strings.Repeat("a", 65) + "@example.com",
+		"a@" + strings.Repeat("b", 250) + ".com",
+	}
+	for _, s := range invalid {
+		assert.ErrorIs(t, Email(s), ErrEmail, s)
+	}
+}
+
+func TestName(t *testing.T) {
+	for _, s := range []string{"Ada", "Jean-Luc Picard", "O'Brien", "Dr. Who", "José Ñúñez", "李小龍", "User2"} {
+		assert.NoError(t, Name(s), s)
+	}
+	for _, s := range []string{"<script>", "Ada\tLovelace", "rm -rf /", "a@b", "Ada\x00"} {
+		assert.ErrorIs(t, Name(s), ErrName, s)
+	}
+}
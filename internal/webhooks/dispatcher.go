@@ -0,0 +1,208 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/backoff"
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/events"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+)
+
+// Sink is the outbox sink that turns every event into deliveries for the
+// subscriptions that want it.
+type Sink struct {
+	db *sql.DB
+}
+
+func NewSink(database *sql.DB) Sink {
+	return Sink{db: database}
+}
+
+func (s Sink) Publish(ctx context.Context, e events.Envelope) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = enqueueDeliveries(ctx, s.db, e, payload)
+	return err
+}
+
+// claimQuery leases due deliveries: pushing next_attempt_at past the lease
+// hides them from other dispatchers while the request is in flight, and hands
+// them back if this process dies before recording the outcome.
+const claimQuery = `
+	UPDATE webhook_deliveries d
+	SET next_attempt_at = NOW() + MAKE_INTERVAL(secs => $2)
+	FROM (
+		SELECT w.id, s.url, s.secret
+		FROM webhook_deliveries w
+		JOIN webhook_subscriptions s ON s.id = w.subscription_id
+		WHERE w.status = 'pending' AND w.next_attempt_at <= NOW() AND s.active
+		ORDER BY w.next_attempt_at
+		LIMIT $1
+		FOR UPDATE OF w SKIP LOCKED
+	) due
+	WHERE d.id = due.id
+	RETURNING d.id, d.event_type, d.payload, d.attempts, due.url, due.secret`
+
+type claimed struct {
+	id        int64
+	eventType string
+	payload   []byte
+	attempts  int
+	url       string
+	secret    string
+}
+
+// Dispatcher sends pending deliveries.
+type Dispatcher struct {
+	db          *sql.DB
+	client      *http.Client
+	maxAttempts int
+	batchSize   int
+	lease       time.Duration
+	backoff     backoff.Policy
+}
+
+// NewDispatcher returns a Dispatcher that gives up on a delivery after
+// maxAttempts failed requests and marks it dead.
+func NewDispatcher(database *sql.DB, client *http.Client, maxAttempts, batchSize int) *Dispatcher {
+	return &Dispatcher{
+		db:          database,
+		client:      client,
+		maxAttempts: max(maxAttempts, 1),
+		batchSize:   max(batchSize, 1),
+		lease:       client.Timeout + 30*time.Second,
+		backoff:     backoff.Policy{Initial: 10 * time.Second, Max: 6 * time.Hour, Multiplier: 3, Jitter: 0.2},
+	}
+}
+
+// Run sends due deliveries every interval until ctx is done. Full batches are
+// followed immediately by the next one.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	for {
+		n, err := d.Process(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.Error("webhook dispatcher", "error", err)
+		}
+		if n == d.batchSize && err == nil {
+			continue
+		}
+		if backoff.Sleep(ctx, interval) != nil {
+			return
+		}
+	}
+}
+
+// Process sends one batch of due deliveries and returns how many it attempted.
+func (d *Dispatcher) Process(ctx context.Context) (int, error) {
+	rows, err := d.db.QueryContext(ctx, claimQuery, d.batchSize, d.lease.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	var batch []claimed
+	for rows.Next() {
+		var c claimed
+		if err := rows.Scan(&c.id, &c.eventType, &c.payload, &c.attempts, &c.url, &c.secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, c)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, c := range batch {
+		start := time.Now()
+		status, sendErr := d.send(ctx, c)
+		if err := d.record(ctx, c, status, sendErr, time.Since(start)); err != nil {
+			return len(batch), err
+		}
+	}
+	return len(batch), nil
+}
+
+// send posts the payload and returns the response status; any non-2xx status
+// is an error.
+func (d *Dispatcher) send(ctx context.Context, c claimed) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(c.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "craftfolio-webhooks/1")
+	req.Header.Set(IDHeader, fmt.Sprint(c.id))
+	req.Header.Set(EventHeader, c.eventType)
+	req.Header.Set(SignatureHeader, Sign(c.secret, time.Now(), c.payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// record logs the attempt and moves the delivery on: succeeded, retried later
+// or dead once the attempts are used up.
+func (d *Dispatcher) record(ctx context.Context, c claimed, status int, sendErr error, took time.Duration) error {
+	ctx = context.WithoutCancel(ctx)
+	statusCode := sql.NullInt64{Int64: int64(status), Valid: status != 0}
+	errText := sql.NullString{}
+	if sendErr != nil {
+		errText = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+
+	return db.WithTx(ctx, d.db, func(tx db.DBTX) error {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO webhook_delivery_attempts (delivery_id, status_code, error, duration_ms)
+			VALUES ($1, $2, $3, $4)
+		`, c.id, statusCode, errText, float64(took.Microseconds())/1000)
+		if err != nil {
+			return err
+		}
+
+		attempts := c.attempts + 1
+		switch {
+		case sendErr == nil:
+			metrics.WebhookDeliveries.WithLabelValues(StatusSucceeded).Inc()
+			_, err = tx.ExecContext(ctx, `
+				UPDATE webhook_deliveries SET status = 'succeeded', attempts = $2,
+					last_status_code = $3, last_error = NULL, delivered_at = NOW()
+				WHERE id = $1
+			`, c.id, attempts, statusCode)
+		case attempts >= d.maxAttempts:
+			metrics.WebhookDeliveries.WithLabelValues(StatusDead).Inc()
+			slog.Warn("webhook delivery dead-lettered", "delivery_id", c.id, "attempts", attempts, "error", sendErr)
+			_, err = tx.ExecContext(ctx, `
+				UPDATE webhook_deliveries SET status = 'dead', attempts = $2,
+					last_status_code = $3, last_error = $4
+				WHERE id = $1
+			`, c.id, attempts, statusCode, errText)
+		default:
+			metrics.WebhookDeliveries.WithLabelValues("failed").Inc()
+			_, err = tx.ExecContext(ctx, `
+				UPDATE webhook_deliveries SET attempts = $2, last_status_code = $3, last_error = $4,
+					next_attempt_at = NOW() + MAKE_INTERVAL(secs => $5)
+				WHERE id = $1
+			`, c.id, attempts, statusCode, errText, d.backoff.Delay(c.attempts).Seconds())
+		}
+		return err
+	})
+}
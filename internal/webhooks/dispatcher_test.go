@@ -0,0 +1,133 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/events"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receiver is a partner endpoint that verifies signatures and answers with
+// status until it is changed.
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	status   int
+	received []events.Envelope
+	errs     []error
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if err := Verify(rc.secret, r.Header.Get(SignatureHeader), body, 5*time.Minute, time.Now()); err != nil {
+		rc.errs = append(rc.errs, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var e events.Envelope
+	_ = json.Unmarshal(body, &e)
+	if e.Type != r.Header.Get(EventHeader) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if rc.status == http.StatusOK {
+		rc.received = append(rc.received, e)
+	}
+	w.WriteHeader(rc.status)
+}
+
+func setupDispatcher(t *testing.T, rc *receiver, maxAttempts int) (*Dispatcher, Subscription) {
+	conn, _ := db.Connect()
+	_, _ = conn.Exec("DELETE FROM webhook_subscriptions")
+	srv := httptest.NewServer(rc)
+	t.Cleanup(srv.Close)
+
+	sub := Subscription{URL: srv.URL, Secret: rc.secret, Events: []string{events.TypeUserCreated}, Active: true}
+	assert.NoError(t, CreateSubscriptionInDB(context.Background(), conn, &sub))
+	return NewDispatcher(conn, srv.Client(), maxAttempts, 10), sub
+}
+
+func TestDispatcherDeliversSignedEvents(t *testing.T) {
+	rc := &receiver{secret: "whsec_test", status: http.StatusOK}
+	d, sub := setupDispatcher(t, rc, 3)
+	ctx := context.Background()
+
+	sink := NewSink(d.db)
+	assert.NoError(t, sink.Publish(ctx, events.Envelope{ID: 101, Type: events.TypeUserCreated, UserID: 1, Data: json.RawMessage(`{}`)}))
+	assert.NoError(t, sink.Publish(ctx, events.Envelope{ID: 101, Type: events.TypeUserCreated, UserID: 1, Data: json.RawMessage(`{}`)}))
+	assert.NoError(t, sink.Publish(ctx, events.Envelope{ID: 102, Type: events.TypeUserDeleted, UserID: 1, Data: json.RawMessage(`{}`)}))
+
+	n, err := d.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n, "The duplicate and the filtered event type should not be delivered")
+	assert.Empty(t, rc.errs)
+	if assert.Len(t, rc.received, 1) {
+		assert.Equal(t, int64(101), rc.received[0].ID)
+	}
+
+	deliveries, total, err := ListDeliveriesFromDB(ctx, d.db, sub.ID, StatusSucceeded, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, total)
+	got, err := GetDeliveryFromDB(ctx, d.db, sub.ID, deliveries[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got.Attempts)
+	assert.NotNil(t, got.DeliveredAt)
+	if assert.Len(t, got.Log, 1) {
+		assert.Equal(t, http.StatusOK, *got.Log[0].StatusCode)
+	}
+}
+
+func TestDispatcherRetriesThenDeadLetters(t *testing.T) {
+	rc := &receiver{secret: "whsec_test", status: http.StatusInternalServerError}
+	d, sub := setupDispatcher(t, rc, 2)
+	ctx := context.Background()
+
+	assert.NoError(t, NewSink(d.db).Publish(ctx, events.Envelope{ID: 201, Type: events.TypeUserCreated, UserID: 2, Data: json.RawMessage(`{}`)}))
+
+	_, err := d.Process(ctx)
+	assert.NoError(t, err)
+	deliveries, _, _ := ListDeliveriesFromDB(ctx, d.db, sub.ID, "", 10, 0)
+	if !assert.Len(t, deliveries, 1) {
+		return
+	}
+	id := deliveries[0].ID
+	got, _ := GetDeliveryFromDB(ctx, d.db, sub.ID, id)
+	assert.Equal(t, StatusPending, got.Status)
+	assert.Equal(t, 1, got.Attempts)
+	assert.True(t, got.NextAttemptAt.After(time.Now()), "A failed delivery should back off")
+
+	n, err := d.Process(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n, "Nothing is due during the backoff")
+
+	// skip the backoff
+	_, _ = d.db.Exec("UPDATE webhook_deliveries SET next_attempt_at = NOW() WHERE id = $1", id)
+	_, err = d.Process(ctx)
+	assert.NoError(t, err)
+	got, _ = GetDeliveryFromDB(ctx, d.db, sub.ID, id)
+	assert.Equal(t, StatusDead, got.Status)
+	assert.Equal(t, 2, got.Attempts)
+	assert.Equal(t, http.StatusInternalServerError, *got.LastStatusCode)
+	assert.Len(t, got.Log, 2)
+
+	// a manual redelivery succeeds once the receiver is back
+	rc.status = http.StatusOK
+	assert.NoError(t, RedeliverInDB(ctx, d.db, sub.ID, id))
+	_, err = d.Process(ctx)
+	assert.NoError(t, err)
+	got, _ = GetDeliveryFromDB(ctx, d.db, sub.ID, id)
+	assert.Equal(t, StatusSucceeded, got.Status)
+	assert.Len(t, got.Log, 3, "Redelivery keeps the earlier attempts in the log")
+	assert.Len(t, rc.received, 1)
+}
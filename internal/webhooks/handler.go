@@ -0,0 +1,381 @@
+package webhooks
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/events"
+	httphelper "gonesoft/go-dev-portfolio/internal/http"
+)
+
+// requireAdmin answers 403 unless the caller is an admin. Every webhook
+// endpoint exposes partner URLs and secrets, so all of them require it.
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if !auth.FromContext(r.Context()).HasRole(auth.RoleAdmin) {
+		httphelper.Error(w, http.StatusForbidden, "Managing webhooks requires the admin role")
+		return false
+	}
+	return true
+}
+
+// validateSubscription returns a message describing what is wrong with req,
+// or "" when it is valid.
+func validateSubscription(req SubscriptionRequest) string {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+	for _, e := range req.Events {
+		switch e {
+		case events.TypeUserCreated, events.TypeUserUpdated, events.TypeUserDeleted, events.TypeUserRestored:
+		default:
+			return "invalid event " + strconv.Quote(e) + ": allowed user.created,user.updated,user.deleted,user.restored"
+		}
+	}
+	return ""
+}
+
+// ListSubscriptions handles GET /webhooks. Secrets are not returned.
+func ListSubscriptions(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	subs, err := ListSubscriptionsFromDB(r.Context(), reader)
+	if err != nil {
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch webhooks")
+		return
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	httphelper.JSON(w, http.StatusOK, subs)
+}
+
+// CreateSubscription handles POST /webhooks. The response is the only place
+// the secret is ever shown; one is generated when the request has none.
+func CreateSubscription(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req SubscriptionRequest
+	if err := httphelper.DecodeJSON(w, r, &req); err != nil {
+		httphelper.DecodeError(w, err)
+		return
+	}
+	if msg := validateSubscription(req); msg != "" {
+		httphelper.Error(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	sub := Subscription{URL: req.URL, Secret: req.Secret, Events: req.Events, Active: true}
+	if sub.Secret == "" {
+		sub.Secret = newSecret()
+	}
+	if sub.Events == nil {
+		sub.Events = []string{}
+	}
+	if req.Active != nil {
+		sub.Active = *req.Active
+	}
+
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	if err := CreateSubscriptionInDB(r.Context(), database, &sub); err != nil {
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to create webhook")
+		return
+	}
+	httphelper.JSON(w, http.StatusCreated, sub)
+}
+
+// GetSubscription handles GET /webhooks/{id}.
+func GetSubscription(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	sub, err := GetSubscriptionFromDB(r.Context(), reader, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			httphelper.Error(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch webhook")
+		return
+	}
+	sub.Secret = ""
+	httphelper.JSON(w, http.StatusOK, sub)
+}
+
+// UpdateSubscription handles PUT /webhooks/{id}. Leaving out the secret keeps
+// the current one and leaving out active keeps the current state.
+func UpdateSubscription(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	var req SubscriptionRequest
+	if err := httphelper.DecodeJSON(w, r, &req); err != nil {
+		httphelper.DecodeError(w, err)
+		return
+	}
+	if msg := validateSubscription(req); msg != "" {
+		httphelper.Error(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	var sub Subscription
+	err = db.WithTx(r.Context(), database, func(tx db.DBTX) error {
+		current, err := GetSubscriptionFromDB(r.Context(), tx, id)
+		if err != nil {
+			return err
+		}
+		sub = Subscription{ID: id, URL: req.URL, Secret: current.Secret, Events: req.Events, Active: current.Active}
+		if req.Secret != "" {
+			sub.Secret = req.Secret
+		}
+		if sub.Events == nil {
+			sub.Events = []string{}
+		}
+		if req.Active != nil {
+			sub.Active = *req.Active
+		}
+		return UpdateSubscriptionInDB(r.Context(), tx, &sub)
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			httphelper.Error(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to update webhook")
+		return
+	}
+	sub.Secret = ""
+	httphelper.JSON(w, http.StatusOK, sub)
+}
+
+// DeleteSubscription handles DELETE /webhooks/{id}. Pending deliveries are
+// dropped with it.
+func DeleteSubscription(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	if err := DeleteSubscriptionFromDB(r.Context(), database, id); err != nil {
+		if err == sql.ErrNoRows {
+			httphelper.Error(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to delete webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListDeliveries handles GET /webhooks/{id}/deliveries?status=&page=&limit=.
+func ListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	q := r.URL.Query()
+	status := strings.ToLower(q.Get("status"))
+	switch status {
+	case "", StatusPending, StatusSucceeded, StatusDead:
+	default:
+		httphelper.Error(w, http.StatusBadRequest, "invalid status: allowed pending,succeeded,dead")
+		return
+	}
+	page, _ := strconv.Atoi(q.Get("page"))
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	if _, err := GetSubscriptionFromDB(r.Context(), reader, id); err != nil {
+		if err == sql.ErrNoRows {
+			httphelper.Error(w, http.StatusNotFound, "Webhook not found")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch webhook")
+		return
+	}
+	deliveries, total, err := ListDeliveriesFromDB(r.Context(), reader, id, status, limit, (page-1)*limit)
+	if err != nil {
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch deliveries")
+		return
+	}
+
+	httphelper.JSON(w, http.StatusOK, map[string]interface{}{
+		"page":        page,
+		"limit":       limit,
+		"total":       total,
+		"total_pages": (total + limit - 1) / limit,
+		"data":        deliveries,
+	})
+}
+
+// deliveryPath parses the subscription and delivery IDs of
+// /webhooks/{id}/deliveries/{delivery}, answering 400 when either is invalid.
+func deliveryPath(w http.ResponseWriter, r *http.Request) (int, int64, bool) {
+	subID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || subID <= 0 {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid webhook ID")
+		return 0, 0, false
+	}
+	id, err := strconv.ParseInt(r.PathValue("delivery"), 10, 64)
+	if err != nil || id <= 0 {
+		httphelper.Error(w, http.StatusBadRequest, "Invalid delivery ID")
+		return 0, 0, false
+	}
+	return subID, id, true
+}
+
+// GetDelivery handles GET /webhooks/{id}/deliveries/{delivery} and includes
+// the log of every attempt.
+func GetDelivery(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	subID, id, ok := deliveryPath(w, r)
+	if !ok {
+		return
+	}
+	reader, err := db.ConnectReader(r.Context())
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	d, err := GetDeliveryFromDB(r.Context(), reader, subID, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			httphelper.Error(w, http.StatusNotFound, "Delivery not found")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch delivery")
+		return
+	}
+	httphelper.JSON(w, http.StatusOK, d)
+}
+
+// Redeliver handles POST /webhooks/{id}/deliveries/{delivery}/redeliver. The delivery is
+// queued again with a fresh attempt budget and sent by the dispatcher.
+func Redeliver(w http.ResponseWriter, r *http.Request) {
+	if !requireAdmin(w, r) {
+		return
+	}
+	subID, id, ok := deliveryPath(w, r)
+	if !ok {
+		return
+	}
+	database, err := db.Connect()
+	if err != nil {
+		httphelper.Error(w, http.StatusServiceUnavailable, "Failed to connect to the database")
+		return
+	}
+	if err := RedeliverInDB(r.Context(), database, subID, id); err != nil {
+		if err == sql.ErrNoRows {
+			httphelper.Error(w, http.StatusNotFound, "Delivery not found")
+			return
+		}
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to redeliver")
+		return
+	}
+	d, err := GetDeliveryFromDB(r.Context(), database, subID, id)
+	if err != nil {
+		if db.Unavailable(err) {
+			httphelper.Error(w, http.StatusServiceUnavailable, "Database unavailable")
+			return
+		}
+		httphelper.Error(w, http.StatusInternalServerError, "Failed to fetch delivery")
+		return
+	}
+	httphelper.JSON(w, http.StatusAccepted, d)
+}
@@ -0,0 +1,37 @@
+package webhooks
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gonesoft/go-dev-portfolio/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscriptionBodies(t *testing.T) {
+	t.Setenv("HTTP_MAX_BODY_BYTES", "64")
+	admin := auth.Principal{Subject: "ops", Roles: []string{auth.RoleAdmin}}
+
+	tests := []struct {
+		name, method, body string
+		handler            http.HandlerFunc
+		want               int
+	}{
+		{"unknown field", http.MethodPost, `{"url":"https://example.com","bogus":1}`, CreateSubscription, http.StatusBadRequest},
+		{"too large", http.MethodPost, `{"url":"https://example.com/` + strings.Repeat("a", 64) + `"}`, CreateSubscription, http.StatusRequestEntityTooLarge},
+		{"update unknown field", http.MethodPut, `{"url":"https://example.com","bogus":1}`, UpdateSubscription, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/webhooks/1", strings.NewReader(tt.body))
+			req.SetPathValue("id", "1")
+			req = req.WithContext(auth.WithPrincipal(req.Context(), admin))
+			rec := httptest.NewRecorder()
+			tt.handler(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+// Package webhooks pushes user events to partner systems. Subscriptions pick
+// the event types they want; every event becomes one delivery per matching
+// subscription, signed with the subscription secret and retried with backoff
+// until it succeeds or is dead-lettered.
+package webhooks
+
+import "time"
+
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead"
+)
+
+// Subscription is a partner endpoint. An empty Events list receives every event type.
+type Subscription struct {
+	ID        int       `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SubscriptionRequest is the body of POST and PUT /webhooks. A missing secret
+// on create is generated; on update it keeps the current one.
+type SubscriptionRequest struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// Delivery is one event sent, or to be sent, to one subscription.
+type Delivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int        `json:"subscription_id"`
+	EventID        int64      `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"`
+	LastStatusCode *int       `json:"last_status_code,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	Log            []Attempt  `json:"log,omitempty"`
+}
+
+// Attempt is one HTTP request made for a delivery.
+type Attempt struct {
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  *int      `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMS  float64   `json:"duration_ms"`
+}
@@ -0,0 +1,193 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gonesoft/go-dev-portfolio/internal/db"
+	"gonesoft/go-dev-portfolio/internal/events"
+	"gonesoft/go-dev-portfolio/internal/metrics"
+
+	"github.com/lib/pq"
+)
+
+func CreateSubscriptionInDB(ctx context.Context, q db.DBTX, sub *Subscription) error {
+	defer metrics.ObserveQuery("create_webhook", time.Now())
+	return q.QueryRowContext(ctx, `
+		INSERT INTO webhook_subscriptions (url, secret, event_types, active)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, sub.URL, sub.Secret, pq.Array(sub.Events), sub.Active).Scan(&sub.ID, &sub.CreatedAt)
+}
+
+func ListSubscriptionsFromDB(ctx context.Context, q db.DBTX) ([]Subscription, error) {
+	defer metrics.ObserveQuery("list_webhooks", time.Now())
+	rows, err := q.QueryContext(ctx, `
+		SELECT id, url, secret, event_types, active, created_at
+		FROM webhook_subscriptions ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []Subscription{}
+	for rows.Next() {
+		var sub Subscription
+		if err := rows.Scan(&sub.ID, &sub.URL, &sub.Secret, pq.Array(&sub.Events), &sub.Active, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func GetSubscriptionFromDB(ctx context.Context, q db.DBTX, id int) (Subscription, error) {
+	defer metrics.ObserveQuery("get_webhook", time.Now())
+	var sub Subscription
+	err := q.QueryRowContext(ctx, `
+		SELECT id, url, secret, event_types, active, created_at
+		FROM webhook_subscriptions WHERE id = $1
+	`, id).Scan(&sub.ID, &sub.URL, &sub.Secret, pq.Array(&sub.Events), &sub.Active, &sub.CreatedAt)
+	return sub, err
+}
+
+// UpdateSubscriptionInDB overwrites url, secret, event filter and active flag.
+func UpdateSubscriptionInDB(ctx context.Context, q db.DBTX, sub *Subscription) error {
+	defer metrics.ObserveQuery("update_webhook", time.Now())
+	return q.QueryRowContext(ctx, `
+		UPDATE webhook_subscriptions SET url = $2, secret = $3, event_types = $4, active = $5
+		WHERE id = $1
+		RETURNING created_at
+	`, sub.ID, sub.URL, sub.Secret, pq.Array(sub.Events), sub.Active).Scan(&sub.CreatedAt)
+}
+
+// DeleteSubscriptionFromDB removes a subscription together with its deliveries.
+func DeleteSubscriptionFromDB(ctx context.Context, q db.DBTX, id int) error {
+	defer metrics.ObserveQuery("delete_webhook", time.Now())
+	result, err := q.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// enqueueDeliveries creates a pending delivery of payload for every active
+// subscription that wants the event. Seeing the same event again is a no-op.
+func enqueueDeliveries(ctx context.Context, q db.DBTX, e events.Envelope, payload []byte) (int64, error) {
+	defer metrics.ObserveQuery("enqueue_webhook_deliveries", time.Now())
+	result, err := q.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3 FROM webhook_subscriptions
+		WHERE active AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))
+		ON CONFLICT (subscription_id, event_id) DO NOTHING
+	`, e.ID, e.Type, payload)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deliveryColumns = `id, subscription_id, event_id, event_type, status, attempts,
+	CASE WHEN status = 'pending' THEN next_attempt_at END,
+	last_status_code, COALESCE(last_error, ''), created_at, delivered_at`
+
+func scanDelivery(row interface{ Scan(...any) error }) (Delivery, error) {
+	var d Delivery
+	err := row.Scan(&d.ID, &d.SubscriptionID, &d.EventID, &d.EventType, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &d.DeliveredAt)
+	return d, err
+}
+
+// ListDeliveriesFromDB returns the deliveries of a subscription, newest first,
+// optionally filtered by status, and the total number of matches.
+func ListDeliveriesFromDB(ctx context.Context, q db.DBTX, subscriptionID int, status string, limit, offset int) ([]Delivery, int, error) {
+	defer metrics.ObserveQuery("list_webhook_deliveries", time.Now())
+	var total int
+	err := q.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM webhook_deliveries
+		WHERE subscription_id = $1 AND ($2 = '' OR status = $2)
+	`, subscriptionID, status).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE subscription_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4
+	`, subscriptionID, status, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	deliveries := []Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, total, rows.Err()
+}
+
+// GetDeliveryFromDB returns a delivery of a subscription together with the log
+// of its attempts.
+func GetDeliveryFromDB(ctx context.Context, q db.DBTX, subscriptionID int, id int64) (Delivery, error) {
+	defer metrics.ObserveQuery("get_webhook_delivery", time.Now())
+	d, err := scanDelivery(q.QueryRowContext(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE id = $1 AND subscription_id = $2`, id, subscriptionID))
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT attempted_at, status_code, COALESCE(error, ''), duration_ms
+		FROM webhook_delivery_attempts WHERE delivery_id = $1 ORDER BY id
+	`, id)
+	if err != nil {
+		return Delivery{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a Attempt
+		if err := rows.Scan(&a.AttemptedAt, &a.StatusCode, &a.Error, &a.DurationMS); err != nil {
+			return Delivery{}, err
+		}
+		d.Log = append(d.Log, a)
+	}
+	return d, rows.Err()
+}
+
+// RedeliverInDB puts a delivery back in the queue with a fresh attempt budget,
+// whatever its status. The log of earlier attempts is kept.
+func RedeliverInDB(ctx context.Context, q db.DBTX, subscriptionID int, id int64) error {
+	defer metrics.ObserveQuery("redeliver_webhook", time.Now())
+	result, err := q.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), delivered_at = NULL
+		WHERE id = $1 AND subscription_id = $2
+	`, id, subscriptionID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
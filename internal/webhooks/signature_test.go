@@ -0,0 +1,21 @@
+package webhooks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"user.created"}`)
+	header := Sign("secret", now, body)
+	assert.Regexp(t, `^t=1700000000,v1=[0-9a-f]{64}$`, header)
+
+	assert.NoError(t, Verify("secret", header, body, 5*time.Minute, now.Add(time.Minute)))
+	assert.ErrorIs(t, Verify("other", header, body, 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", header, []byte(`{}`), 5*time.Minute, now), ErrInvalidSignature)
+	assert.ErrorIs(t, Verify("secret", header, body, 5*time.Minute, now.Add(time.Hour)), ErrStaleSignature)
+	assert.ErrorIs(t, Verify("secret", "v1=abc", body, 5*time.Minute, now), ErrMissingSignature)
+}
@@ -3,7 +3,7 @@ package testutils
 import (
 	"database/sql"
 	"gonesoft/go-dev-portfolio/internal/db"
-	"log"
+	"log/slog"
 	"os"
 	"testing"
 
@@ -13,19 +13,20 @@ import (
 var TestDB *sql.DB
 
 func TestMain(m *testing.M) {
-	log.Println("🔧 Setting up test database...")
+	slog.Info("setting up test database")
 
-	TestDB = db.Connect() // Connect using test env vars
-
-	if err := TestDB.Ping(); err != nil {
-		log.Fatalf("❌ Cannot connect to test DB: %v", err)
+	var err error
+	TestDB, err = db.Connect() // Connect using test env vars
+	if err != nil {
+		slog.Error("cannot connect to test database", "error", err)
+		os.Exit(1)
 	}
 
-	log.Println("✅ Test database connected")
+	slog.Info("test database connected")
 
 	code := m.Run()
 
-	log.Println("🧹 Cleaning up test database...")
+	slog.Info("cleaning up test database")
 	TestDB.Close()
 
 	os.Exit(code)